@@ -0,0 +1,92 @@
+// Command segmentdir segments every recording of a collection directory,
+// writing the segments to an output tree that mirrors it.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"raga-recog-pipeline/pkg/audiosegmenter"
+	"raga-recog-pipeline/pkg/progress"
+)
+
+func main() {
+	var opts audiosegmenter.BatchOptions
+	var extensions string
+	var normalize bool
+	var loudness audiosegmenter.LoudnessOptions
+	var showProgress bool
+	var verify audiosegmenter.VerifyOptions
+
+	flag.Float64Var(&opts.SegmentDuration, "duration", 30, "segment length in seconds")
+	flag.Float64Var(&opts.HopDuration, "hop", 0, "seconds between segment starts; 0 cuts back-to-back segments")
+	flag.IntVar(&opts.Concurrency, "concurrency", audiosegmenter.DEFAULT_CONCURRENCY, "maximum number of cuts run at once across all files")
+	flag.StringVar(&opts.TailPolicy, "tail", audiosegmenter.TAIL_KEEP, "what to do with a short final segment: keep, drop, merge or pad")
+	flag.Float64Var(&opts.MinTailDuration, "min-tail", 0, "only apply the tail policy to tails shorter than this many seconds")
+	flag.StringVar(&opts.Backend, "backend", "", "ffmpeg or native; empty picks ffmpeg when it is installed")
+	flag.BoolVar(&opts.Accurate, "accurate", false, "re-encode segments so that they start and end on the requested sample")
+	flag.StringVar(&opts.Output.Format, "format", "", "output format: mp3, wav or flac; empty keeps the source format")
+	flag.BoolVar(&normalize, "normalize", false, "normalize every recording to the integrated loudness given by -lufs")
+	flag.Float64Var(&loudness.TargetLUFS, "lufs", audiosegmenter.EBU_R128_TARGET_LUFS, "integrated loudness -normalize brings recordings to; 0 uses the EBU R128 target")
+	flag.StringVar(&opts.NameTemplate, "name", audiosegmenter.DEFAULT_NAME_TEMPLATE, "segment file name template; must use {name} and {index}, and may use {index:N}, {start}, {end}, {label} and {ext}")
+	flag.StringVar(&opts.Layout, "layout", audiosegmenter.LAYOUT_NESTED, "nested puts every segment in a directory of its own, flat writes them side by side")
+	flag.StringVar(&verify.Action, "verify", "", "check the duration of every segment and flag or quarantine those that are off")
+	flag.Float64Var(&verify.Tolerance, "tolerance", audiosegmenter.DEFAULT_VERIFY_TOLERANCE, "seconds a verified segment may differ from the request")
+	flag.BoolVar(&opts.Overwrite, "overwrite", false, "cut every segment again instead of reusing those of an earlier run")
+	flag.BoolVar(&showProgress, "progress", false, "print progress to stderr as files finish")
+	flag.StringVar(&extensions, "ext", strings.Join(audiosegmenter.DEFAULT_AUDIO_EXTENSIONS, ","), "comma separated extensions of the files to segment")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <input dir> <output dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	for _, ext := range strings.Split(extensions, ",") {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			opts.Extensions = append(opts.Extensions, ext)
+		}
+	}
+
+	if normalize {
+		opts.Loudness = &loudness
+	}
+
+	if verify.Action != "" {
+		opts.Verify = &verify
+	}
+
+	if showProgress {
+		opts.Progress = func(event progress.Event) {
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s %s\n", event.Completed, event.Total, event.Elapsed.Round(time.Second), event.Current)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	summary, err := audiosegmenter.SegmentDirectory(ctx, flag.Arg(0), flag.Arg(1), opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := summary.Write(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -1,26 +1,41 @@
 #!/bin/bash
 set -e
 
-cmd="python3 -m demucs -n ${MODEL} --out /data/output"
+cmd=(python3 -m demucs -n "${MODEL:-htdemucs}" --out /data/output)
 
-# Check if GPU is enabled
-if [ "$GPU" = "true" ]; then
-    cmd="${cmd} --gpus all"
+# GPU=true is still honoured for containers started without DEVICE
+if [ -z "$DEVICE" ] && [ "$GPU" = "true" ]; then
+    DEVICE=cuda
+fi
+if [ -n "$DEVICE" ]; then
+    cmd+=(-d "$DEVICE")
 fi
 
 # Check if MP3 output is enabled
 if [ "$MP3OUTPUT" = "true" ]; then
-    cmd="${cmd} --mp3"
+    cmd+=(--mp3)
 fi
 
 # Check if a split track option is set
 if [ -n "$SPLITTRACK" ]; then
-    cmd="${cmd} --two-stems ${SPLITTRACK}"
+    cmd+=(--two-stems "$SPLITTRACK")
+fi
+
+if [ -n "$SHIFTS" ]; then
+    cmd+=(--shifts "$SHIFTS")
+fi
+
+if [ -n "$OVERLAP" ]; then
+    cmd+=(--overlap "$OVERLAP")
+fi
+
+if [ -n "$SEGMENT" ]; then
+    cmd+=(--segment "$SEGMENT")
 fi
 
 # Add the track to the command
-cmd="${cmd} /data/input/$1"
+cmd+=("/data/input/$1")
 
 # Execute the command
-echo "Running command: $cmd"
-eval $cmd
+echo "Running command: ${cmd[*]}"
+exec "${cmd[@]}"
@@ -2,7 +2,11 @@ module raga-recog-pipeline
 
 go 1.21.5
 
-require github.com/u2takey/ffmpeg-go v0.5.0
+require (
+	github.com/docker/docker v25.0.4+incompatible
+	github.com/hajimehoshi/go-mp3 v0.3.4
+	github.com/u2takey/ffmpeg-go v0.5.0
+)
 
 require (
 	github.com/Azure/go-ansiterm v0.0.0-20210617225240-d185dfc1b5a1 // indirect
@@ -11,7 +15,6 @@ require (
 	github.com/containerd/containerd v1.7.14 // indirect
 	github.com/containerd/log v0.1.0 // indirect
 	github.com/distribution/reference v0.5.0 // indirect
-	github.com/docker/docker v25.0.4+incompatible // indirect
 	github.com/docker/go-connections v0.5.0 // indirect
 	github.com/docker/go-units v0.5.0 // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
@@ -1,6 +1,7 @@
 package audiosegmenter
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -9,15 +10,208 @@ import (
 	"strconv"
 	"sync"
 
-	ffmpeg_go "github.com/u2takey/ffmpeg-go"
+	"raga-recog-pipeline/pkg/progress"
 )
 
+const DEFAULT_CONCURRENCY = 10
+
+// MIN_HOP_DURATION is the shortest distance in seconds allowed between
+// segment starts, which bounds the number of windows cut from a source.
+const MIN_HOP_DURATION = 0.01
+
 type FormatInfo struct {
-	Duration string `json:"duration"`
+	FormatName string            `json:"format_name"`
+	Duration   string            `json:"duration"`
+	StartTime  string            `json:"start_time"`
+	BitRate    string            `json:"bit_rate"`
+	NbStreams  int               `json:"nb_streams"`
+	Tags       map[string]string `json:"tags"`
+}
+
+type StreamInfo struct {
+	Index            int               `json:"index"`
+	CodecName        string            `json:"codec_name"`
+	CodecType        string            `json:"codec_type"`
+	SampleFmt        string            `json:"sample_fmt"`
+	SampleRate       string            `json:"sample_rate"`
+	Channels         int               `json:"channels"`
+	ChannelLayout    string            `json:"channel_layout"`
+	BitsPerSample    int               `json:"bits_per_sample"`
+	BitsPerRawSample string            `json:"bits_per_raw_sample"`
+	BitRate          string            `json:"bit_rate"`
+	StartTime        string            `json:"start_time"`
+	Duration         string            `json:"duration"`
+	Tags             map[string]string `json:"tags"`
 }
 
 type FFProbeOutput struct {
-	Format FormatInfo `json:"format"`
+	Format  FormatInfo   `json:"format"`
+	Streams []StreamInfo `json:"streams"`
+}
+
+type SegmentBoundary struct {
+	Index int
+	Start float64
+	End   float64
+	// Tail is the tail policy applied to this boundary, if any.
+	Tail string
+	// Label names an annotated range.
+	Label string
+}
+
+func (b SegmentBoundary) Duration() float64 {
+	return b.End - b.Start
+}
+
+// Segment describes one segment cut from a source file. Err is set when the
+// segment could not be produced, in which case only the fields describing the
+// request are guaranteed to be filled in.
+type Segment struct {
+	Index             int        `json:"index"`
+	SourcePath        string     `json:"source_path"`
+	OutputPath        string     `json:"output_path"`
+	Start             float64    `json:"start"`
+	RequestedDuration float64    `json:"requested_duration"`
+	ActualDuration    float64    `json:"actual_duration"`
+	Size              int64      `json:"size"`
+	SHA256            string     `json:"sha256"`
+	Output            OutputSpec `json:"output"`
+	// Label is the annotation the segment was cut for, if any.
+	Label string `json:"label,omitempty"`
+	// Tail is TAIL_MERGE or TAIL_PAD when the tail policy changed this
+	// segment.
+	Tail string `json:"tail,omitempty"`
+	// Reused is set when the segment was left in place from an earlier run
+	// instead of being cut again.
+	Reused bool `json:"reused,omitempty"`
+	// Metadata is the tag metadata of the source recording, shared by all of
+	// its segments. It is nil when the source has no tags.
+	Metadata *RecordingMetadata `json:"metadata,omitempty"`
+	// Loudness is the normalization applied to the source, if any.
+	Loudness *Loudness `json:"loudness,omitempty"`
+	Err      error     `json:"-"`
+}
+
+// SegmentErrors returns the errors of every failed segment in index order.
+func SegmentErrors(segments []Segment) []error {
+	var errs []error
+	for _, segment := range segments {
+		if segment.Err != nil {
+			errs = append(errs, segment.Err)
+		}
+	}
+	return errs
+}
+
+// CutOptions controls how CopyAudioSegment cuts a single segment.
+type CutOptions struct {
+	// Accurate decodes and re-encodes the segment so that it starts and ends
+	// on the requested sample. The default stream copy is much faster but
+	// snaps cuts to the frame boundaries of the source codec.
+	Accurate bool `json:"accurate"`
+	// Output selects the format and sample layout of the written segment.
+	// Any spec that changes the source stream forces a re-encode.
+	Output OutputSpec `json:"output"`
+	// Backend is BACKEND_FFMPEG or BACKEND_NATIVE. Empty picks ffmpeg when
+	// it is installed and the native decoder otherwise.
+	Backend string `json:"backend,omitempty"`
+	// NameTemplate names the segment files, see SegmentPath for the fields
+	// it can use. Empty uses DEFAULT_NAME_TEMPLATE.
+	NameTemplate string `json:"name_template,omitempty"`
+	// Layout is LAYOUT_NESTED or LAYOUT_FLAT. Empty nests every segment in
+	// a directory of its own.
+	Layout string `json:"layout,omitempty"`
+	// Transcoder overrides Backend with a custom implementation.
+	Transcoder Transcoder `json:"-"`
+	// Pad fills the segment with silence up to the requested duration when
+	// the source ends first.
+	Pad bool `json:"-"`
+	// Label is appended to the name of the segment.
+	Label string `json:"-"`
+	// GainDB is applied to the segment, which forces a re-encode.
+	GainDB float64 `json:"-"`
+	// Verify, when set, probes every segment once it is written and checks
+	// that it lasts as long as requested.
+	Verify *VerifyOptions `json:"verify,omitempty"`
+
+	// sourceDuration saves verification from probing the source again when
+	// the caller already knows its duration.
+	sourceDuration float64
+}
+
+type SegmentOptions struct {
+	CutOptions
+	// SegmentDuration is the length of each segment in seconds.
+	SegmentDuration float64 `json:"segment_duration"`
+	// HopDuration is the distance in seconds between consecutive segment
+	// starts, at least MIN_HOP_DURATION. Zero or less cuts back-to-back
+	// segments.
+	HopDuration float64 `json:"hop_duration"`
+	// Concurrency is the maximum number of ffmpeg processes run at once.
+	// Zero or less uses DEFAULT_CONCURRENCY.
+	Concurrency int `json:"concurrency"`
+	// TailPolicy is one of TAIL_KEEP, TAIL_DROP, TAIL_MERGE or TAIL_PAD and
+	// decides what happens to a final segment shorter than SegmentDuration.
+	// Empty keeps it.
+	TailPolicy string `json:"tail_policy,omitempty"`
+	// MinTailDuration limits the tail policy to tails shorter than this many
+	// seconds. Zero applies it to every short tail.
+	MinTailDuration float64 `json:"min_tail_duration,omitempty"`
+	// Loudness, when set, normalizes every source to a target loudness.
+	Loudness *LoudnessOptions `json:"loudness,omitempty"`
+	// Overwrite cuts every segment again, even those an earlier run over the
+	// same source with the same parameters left in the output directory.
+	Overwrite bool `json:"-"`
+	// Progress, when set, is told every time a segment is finished,
+	// including segments that failed or were reused.
+	Progress progress.Func `json:"-"`
+
+	// slots, when set, is shared by several runs so that their cuts together
+	// stay within one concurrency budget.
+	slots chan struct{}
+}
+
+// acquire waits for a free slot of a shared concurrency budget, if any, and
+// returns the function that frees it again.
+func (opts SegmentOptions) acquire(ctx context.Context) (func(), error) {
+	if opts.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case opts.slots <- struct{}{}:
+		return func() { <-opts.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve validates opts and fills in the transcoder and output spec the run
+// will use. fixedLength is set when the segments are cut every
+// SegmentDuration, which then must be positive. Otherwise the boundaries come
+// from elsewhere, and SegmentDuration, HopDuration and the tail policy are
+// cleared so that they do not affect the manifest or the reuse of segments.
+func (opts SegmentOptions) resolve(fixedLength bool) (SegmentOptions, error) {
+	if !fixedLength {
+		opts.SegmentDuration, opts.HopDuration = 0, 0
+		opts.TailPolicy, opts.MinTailDuration = "", 0
+	} else if !(opts.SegmentDuration > 0) || math.IsInf(opts.SegmentDuration, 1) {
+		return opts, fmt.Errorf("segment duration must be positive and finite, got %v", opts.SegmentDuration)
+	} else if math.IsNaN(opts.HopDuration) || math.IsInf(opts.HopDuration, 0) {
+		return opts, fmt.Errorf("hop duration must be finite, got %v", opts.HopDuration)
+	} else if hop := opts.HopDuration; (hop > 0 && hop < MIN_HOP_DURATION) || (hop <= 0 && opts.SegmentDuration < MIN_HOP_DURATION) {
+		return opts, fmt.Errorf("segments must start at least %vs apart, got a hop of %v and a duration of %v", MIN_HOP_DURATION, hop, opts.SegmentDuration)
+	} else if err := validateTailPolicy(opts.TailPolicy, opts.MinTailDuration); err != nil {
+		return opts, err
+	}
+
+	if err := opts.Loudness.Validate(); err != nil {
+		return opts, err
+	}
+
+	var err error
+	opts.CutOptions, err = opts.CutOptions.resolve()
+	return opts, err
 }
 
 func ParseDuration(probeOutput string) (float64, error) {
@@ -37,70 +231,285 @@ func ParseDuration(probeOutput string) (float64, error) {
 	return duration, nil
 }
 
-func CopyAudioSegment(inputFilePath string, segmentIdx int, segmentStart int, segmentDuration int, outputDir string) error {
-	filename := filepath.Base(inputFilePath)
-	filenameWithoutExt := filename[:len(filename)-len(filepath.Ext(filename))]
+// formatSeconds renders seconds for ffmpeg, rounded to the microsecond so
+// that accumulated floating point error does not leak into the arguments.
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(math.Round(seconds*1e6)/1e6, 'f', -1, 64)
+}
 
-	segmentDir := filepath.Join(outputDir, fmt.Sprintf("%s_seg_%d", filenameWithoutExt, segmentIdx))
-	if err := os.MkdirAll(segmentDir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create segment directory %s: %w", segmentDir, err)
+func CopyAudioSegment(ctx context.Context, inputFilePath string, segmentIdx int, segmentStart float64, segmentDuration float64, outputDir string, cut CutOptions) (Segment, error) {
+	segment := Segment{
+		Index:             segmentIdx,
+		SourcePath:        inputFilePath,
+		Start:             segmentStart,
+		RequestedDuration: segmentDuration,
+		Output:            cut.Output,
+		Label:             cut.Label,
 	}
 
-	outputFilePath := filepath.Join(segmentDir, fmt.Sprintf("%s_seg_%d.mp3", filenameWithoutExt, segmentIdx))
+	cut, err := cut.resolve()
+	if err != nil {
+		return segment, err
+	}
 
-	err := ffmpeg_go.Input(inputFilePath).Output(outputFilePath, ffmpeg_go.KwArgs{
-		"ss": strconv.Itoa(segmentStart),
-		"t":  strconv.Itoa(segmentDuration),
-		"c":  "copy",
-		"y":  "",
-	}).Run()
+	expected := segmentDuration
+	if cut.Verify != nil {
+		sourceDuration := cut.sourceDuration
+		if sourceDuration == 0 && !cut.Pad {
+			if sourceDuration, err = cut.Transcoder.ProbeDuration(ctx, inputFilePath); err != nil {
+				return segment, err
+			}
+		}
+		expected = expectedDuration(segmentStart, segmentDuration, sourceDuration, cut.Pad)
+	}
 
+	return writeSegment(ctx, segment, outputDir, cut, expected, func(outputFilePath string) error {
+		return cut.Transcoder.Cut(ctx, inputFilePath, outputFilePath, segmentStart, segmentDuration, cut)
+	})
+}
+
+// writeSegment names the output of segment, has write produce it and fills
+// in what the written file turned out to be. cut must already be resolved.
+// When cut.Verify is set the segment is checked to last expected seconds.
+func writeSegment(ctx context.Context, segment Segment, outputDir string, cut CutOptions, expected float64, write func(outputFilePath string) error) (Segment, error) {
+	segment.Output = cut.Output
+
+	segmentPath, err := SegmentPath(segment, cut)
 	if err != nil {
-		return fmt.Errorf("failed to create segment %d for %s: %w", segmentIdx, outputFilePath, err)
+		return segment, err
 	}
 
-	return nil
-}
+	outputFilePath := filepath.Join(outputDir, segmentPath)
+	segment.OutputPath = outputFilePath
+
+	segmentDir := filepath.Dir(outputFilePath)
+	if err := os.MkdirAll(segmentDir, os.ModePerm); err != nil {
+		return segment, fmt.Errorf("failed to create segment directory %s: %w", segmentDir, err)
+	}
 
-func SegmentAudio(inputFilePath string, segmentDuration int, outputDir string) []error {
-	probeOutput, err := ffmpeg_go.Probe(inputFilePath)
+	// Write to a partial file first so that an interrupted cut never leaves a
+	// truncated segment under its final name.
+	partialFilePath := partialPath(outputFilePath)
+	err = write(partialFilePath)
 
 	if err != nil {
-		return []error{err}
+		os.Remove(partialFilePath)
+		return segment, fmt.Errorf("failed to create segment %d for %s: %w", segment.Index, outputFilePath, err)
 	}
 
-	duration, err := ParseDuration(probeOutput)
+	if err := os.Rename(partialFilePath, outputFilePath); err != nil {
+		os.Remove(partialFilePath)
+		return segment, fmt.Errorf("failed to move segment into place at %s: %w", outputFilePath, err)
+	}
 
+	info, err := os.Stat(outputFilePath)
 	if err != nil {
-		return []error{err}
+		return segment, fmt.Errorf("failed to stat segment %s: %w", outputFilePath, err)
 	}
+	segment.Size = info.Size()
 
-	numberOfSegments := int(math.Ceil(duration / float64(segmentDuration)))
+	segment.SHA256, err = hashFile(outputFilePath)
+	if err != nil {
+		return segment, fmt.Errorf("failed to hash segment %s: %w", outputFilePath, err)
+	}
 
-	errChan := make(chan error, numberOfSegments)
+	segment.ActualDuration, err = cut.Transcoder.ProbeDuration(ctx, outputFilePath)
+	if err != nil {
+		if cut.Verify == nil {
+			return segment, fmt.Errorf("failed to probe segment %s: %w", outputFilePath, err)
+		}
+		// A segment too broken to probe is treated like an empty one.
+		segment.ActualDuration = 0
+	}
+
+	if cut.Verify != nil {
+		return verifySegment(segment, outputDir, expected, *cut.Verify)
+	}
+
+	return segment, nil
+}
+
+// copySegments cuts every boundary on a pool of at most opts.Concurrency
+// workers and returns the segments in the order of boundaries. Once ctx is
+// cancelled running ffmpeg processes are killed and the remaining boundaries
+// are reported as failed with the context error.
+func copySegments(ctx context.Context, inputFilePath string, boundaries []SegmentBoundary, outputDir string, opts SegmentOptions, run *segmentRun) []Segment {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DEFAULT_CONCURRENCY
+	}
+	actualConcurrency := min(concurrency, len(boundaries))
 
 	var wg sync.WaitGroup
+	jobs := make(chan int)
+	segments := make([]Segment, len(boundaries))
+
+	tracker := progress.NewTracker(opts.Progress, len(boundaries))
+	tracker.Start(inputFilePath)
 
-	for i := 0; i < numberOfSegments; i++ {
+	for w := 0; w < actualConcurrency; w++ {
 		wg.Add(1)
 
-		go func(segmentIdx int) {
+		go func() {
 			defer wg.Done()
-			err := CopyAudioSegment(inputFilePath, segmentIdx, segmentIdx*segmentDuration, segmentDuration, outputDir)
 
-			if err != nil {
-				errChan <- fmt.Errorf("error processing segment %d: %w", segmentIdx, err)
+			for i := range jobs {
+				boundary := boundaries[i]
+				segment := Segment{
+					Index:             boundary.Index,
+					SourcePath:        inputFilePath,
+					Start:             boundary.Start,
+					RequestedDuration: boundary.Duration(),
+					Output:            opts.Output,
+					Label:             boundary.Label,
+				}
+
+				cut := opts.CutOptions
+				cut.Pad = boundary.Tail == TAIL_PAD
+				cut.Label = boundary.Label
+
+				err := ctx.Err()
+				if err == nil {
+					if reused, ok := run.reuse(boundary); ok {
+						segment = reused
+					} else if release, slotErr := opts.acquire(ctx); slotErr != nil {
+						err = slotErr
+					} else {
+						segment, err = CopyAudioSegment(ctx, inputFilePath, boundary.Index, boundary.Start, boundary.Duration(), outputDir, cut)
+						release()
+					}
+				}
+				segment.Tail = boundary.Tail
+
+				if err != nil {
+					segment.Err = fmt.Errorf("error processing segment %d: %w", boundary.Index, err)
+				}
+
+				if err := run.complete(i, segment); err != nil && segment.Err == nil {
+					segment.Err = fmt.Errorf("error processing segment %d: %w", boundary.Index, err)
+				}
+
+				segments[i] = segment
+				tracker.Done(inputFilePath)
 			}
-		}(i)
+		}()
+	}
+
+	for i := range boundaries {
+		jobs <- i
 	}
+	close(jobs)
 
 	wg.Wait()
-	close(errChan)
 
-	var errs []error
-	for e := range errChan {
-		errs = append(errs, e)
+	return segments
+}
+
+// SegmentStarts returns the start offsets, in seconds, of every window of
+// segmentDuration seconds taken every hopDuration seconds across an input of
+// the given duration. A hopDuration smaller than segmentDuration produces
+// overlapping windows; a hopDuration of zero or less falls back to
+// back-to-back windows. Windows are generated until one reaches the end of
+// the input, so the offsets are fully determined by the arguments. An empty
+// input, or one with a duration, window or hop that is not finite, has no
+// windows.
+func SegmentStarts(duration float64, segmentDuration float64, hopDuration float64) []float64 {
+	if !(duration > 0) || !(segmentDuration > 0) || math.IsInf(duration, 1) || math.IsInf(segmentDuration, 1) ||
+		math.IsNaN(hopDuration) || math.IsInf(hopDuration, 0) {
+		return nil
+	}
+	if hopDuration <= 0 {
+		hopDuration = segmentDuration
 	}
 
-	return errs
+	// Offsets are computed as multiples of the hop rather than accumulated so
+	// that rounding error does not drift across long inputs.
+	starts := []float64{0}
+	for i := 1; starts[i-1]+segmentDuration < duration; i++ {
+		start := float64(i) * hopDuration
+		if start >= duration {
+			break
+		}
+		starts = append(starts, start)
+	}
+
+	return starts
+}
+
+// SegmentAudio cuts inputFilePath into windows of opts.SegmentDuration
+// seconds, writes a manifest of the run to outputDir and returns one Segment
+// per window in index order. The error is only set when the run could not
+// start or its manifest could not be written; failures of individual
+// segments are reported on the segments themselves.
+func SegmentAudio(ctx context.Context, inputFilePath string, outputDir string, opts SegmentOptions) ([]Segment, error) {
+	opts, err := opts.resolve(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return segmentSource(ctx, inputFilePath, outputDir, opts, nil, func(duration float64) ([]SegmentBoundary, error) {
+		var boundaries []SegmentBoundary
+		for i, start := range SegmentStarts(duration, opts.SegmentDuration, opts.HopDuration) {
+			boundaries = append(boundaries, SegmentBoundary{
+				Index: i,
+				Start: start,
+				End:   start + opts.SegmentDuration,
+			})
+		}
+
+		return ApplyTailPolicy(boundaries, duration, opts.SegmentDuration, opts.TailPolicy, opts.MinTailDuration), nil
+	})
+}
+
+// segmentSource runs the steps SegmentAudio, SegmentAudioOnSilence and
+// SegmentAudioByRanges share. It probes inputFilePath, asks boundaries where
+// to cut a source of its duration, and cuts, normalizes and records the
+// segments in a manifest of the run, along with silence when it is set.
+// opts must be resolved.
+func segmentSource(ctx context.Context, inputFilePath string, outputDir string, opts SegmentOptions, silence *SilenceOptions, boundaries func(duration float64) ([]SegmentBoundary, error)) ([]Segment, error) {
+	// Probing, analysing and hashing the source read it in full, so they
+	// take a slot of the budget the cuts share.
+	release, err := opts.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts, cuts, run, err := startSource(ctx, inputFilePath, outputDir, opts, silence, boundaries)
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err = run.normalize(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := copySegments(ctx, inputFilePath, cuts, outputDir, opts, run)
+	return segments, run.finish(segments)
+}
+
+// startSource probes inputFilePath, decides the boundaries of its segments
+// and starts the run that records them.
+func startSource(ctx context.Context, inputFilePath string, outputDir string, opts SegmentOptions, silence *SilenceOptions, boundaries func(duration float64) ([]SegmentBoundary, error)) (SegmentOptions, []SegmentBoundary, *segmentRun, error) {
+	duration, err := opts.Transcoder.ProbeDuration(ctx, inputFilePath)
+
+	if err != nil {
+		return opts, nil, nil, err
+	}
+
+	opts.sourceDuration = duration
+
+	metadata, err := opts.Transcoder.ProbeMetadata(ctx, inputFilePath)
+
+	if err != nil {
+		return opts, nil, nil, err
+	}
+
+	cuts, err := boundaries(duration)
+	if err != nil {
+		return opts, nil, nil, err
+	}
+
+	run, err := startSegmentRun(inputFilePath, outputDir, opts, silence, metadata)
+	return opts, cuts, run, err
 }
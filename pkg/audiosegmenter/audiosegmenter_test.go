@@ -1,14 +1,20 @@
 package audiosegmenter
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	ffmpeg_go "github.com/u2takey/ffmpeg-go"
+
+	"raga-recog-pipeline/pkg/progress"
 )
 
 const INPUT_DIR = "../../sample/kiravani"
@@ -19,6 +25,16 @@ func GET_EXPECTED_OUTPUT_FILE_NAME(segmentIdx int) string {
 	return fmt.Sprintf("01-kaligiyuNTEgadA_galgunu-kIravANi_seg_%d.mp3", segmentIdx)
 }
 
+// requireFFmpeg skips the tests that run the ffmpeg and ffprobe binaries on
+// machines without them.
+func requireFFmpeg(t *testing.T) {
+	t.Helper()
+
+	if !FFmpegAvailable() {
+		t.Skip("ffmpeg and ffprobe are not on the PATH")
+	}
+}
+
 type ParseDurationTest struct {
 	name         string
 	probeOutput  string
@@ -62,7 +78,101 @@ func TestParseDuration(t *testing.T) {
 	}
 }
 
+type SegmentStartsTest struct {
+	name            string
+	duration        float64
+	segmentDuration float64
+	hopDuration     float64
+	wantStarts      []float64
+}
+
+func TestSegmentStarts(t *testing.T) {
+	tests := []SegmentStartsTest{
+		{
+			name:            "back-to-back windows",
+			duration:        95,
+			segmentDuration: 30,
+			hopDuration:     30,
+			wantStarts:      []float64{0, 30, 60, 90},
+		},
+		{
+			name:            "non-positive hop falls back to back-to-back",
+			duration:        95,
+			segmentDuration: 30,
+			hopDuration:     0,
+			wantStarts:      []float64{0, 30, 60, 90},
+		},
+		{
+			name:            "overlapping windows",
+			duration:        60,
+			segmentDuration: 30,
+			hopDuration:     10,
+			wantStarts:      []float64{0, 10, 20, 30},
+		},
+		{
+			name:            "overlapping windows with tail",
+			duration:        65,
+			segmentDuration: 30,
+			hopDuration:     10,
+			wantStarts:      []float64{0, 10, 20, 30, 40},
+		},
+		{
+			name:            "input shorter than one window",
+			duration:        12.5,
+			segmentDuration: 30,
+			hopDuration:     10,
+			wantStarts:      []float64{0},
+		},
+		{
+			name:            "hop larger than window",
+			duration:        100,
+			segmentDuration: 10,
+			hopDuration:     40,
+			wantStarts:      []float64{0, 40, 80},
+		},
+		{
+			name:            "empty input",
+			duration:        0,
+			segmentDuration: 30,
+			hopDuration:     30,
+			wantStarts:      nil,
+		},
+		{
+			name:            "negative duration",
+			duration:        -5,
+			segmentDuration: 30,
+			hopDuration:     10,
+			wantStarts:      nil,
+		},
+		{
+			name:            "unbounded duration",
+			duration:        math.Inf(1),
+			segmentDuration: 30,
+			hopDuration:     10,
+			wantStarts:      nil,
+		},
+		{
+			name:            "NaN hop",
+			duration:        60,
+			segmentDuration: 30,
+			hopDuration:     math.NaN(),
+			wantStarts:      nil,
+		},
+	}
+
+	for _, startsTest := range tests {
+		t.Run(startsTest.name, func(t *testing.T) {
+			starts := SegmentStarts(startsTest.duration, startsTest.segmentDuration, startsTest.hopDuration)
+			if !reflect.DeepEqual(starts, startsTest.wantStarts) {
+				t.Errorf("SegmentStarts() = %v, want %v", starts, startsTest.wantStarts)
+			}
+		})
+	}
+}
+
 func TestCopyAudioSegment_Positive(t *testing.T) {
+	requireFFmpeg(t)
+
 	inputDir := INPUT_DIR
 	outputDir := OUTPUT_DIR
 	segmentDuration := 30
@@ -75,7 +185,7 @@ func TestCopyAudioSegment_Positive(t *testing.T) {
 	segmentIdx := 0
 	segmentStart := segmentIdx * segmentDuration
 
-	err := CopyAudioSegment(inputFilePath, segmentIdx, segmentStart, segmentDuration, outputDir)
+	segment, err := CopyAudioSegment(context.Background(), inputFilePath, segmentIdx, float64(segmentStart), float64(segmentDuration), outputDir, CutOptions{Backend: BACKEND_FFMPEG})
 	if err != nil {
 		t.Fatalf("Failed to copy audio segment: %v", err)
 	}
@@ -87,6 +197,13 @@ func TestCopyAudioSegment_Positive(t *testing.T) {
 		t.Fatalf("Output file does not exist: %s", expectedOutputFileName)
 	}
 
+	if segment.OutputPath != expectedOutputFileName {
+		t.Errorf("Segment output path = %s, want %s", segment.OutputPath, expectedOutputFileName)
+	}
+	if segment.Size <= 0 {
+		t.Errorf("Segment size = %d, want a non-empty file", segment.Size)
+	}
+
 	probeOutput, err := ffmpeg_go.Probe(expectedOutputFileName)
 	if err != nil {
 		t.Fatalf("Failed to probe output file: %v", err)
@@ -103,6 +220,8 @@ func TestCopyAudioSegment_Positive(t *testing.T) {
 }
 
 func TestCopyAudioSegment_LastSegment(t *testing.T) {
+	requireFFmpeg(t)
+
 	inputDir := INPUT_DIR
 	outputDir := OUTPUT_DIR
 
@@ -133,7 +252,7 @@ func TestCopyAudioSegment_LastSegment(t *testing.T) {
 		expectedLastSegmentDuration = segmentDuration
 	}
 
-	err = CopyAudioSegment(inputFilePath, numberOfSegments, lastSegmentStart, segmentDuration, outputDir)
+	_, err = CopyAudioSegment(context.Background(), inputFilePath, numberOfSegments, float64(lastSegmentStart), float64(segmentDuration), outputDir, CutOptions{Backend: BACKEND_FFMPEG})
 	if err != nil {
 		t.Fatalf("Failed to copy last audio segment: %v", err)
 	}
@@ -170,7 +289,7 @@ func TestCopyAudioSegment_NegativeCases(t *testing.T) {
 		defer os.RemoveAll(outputDir) // Clean up after test
 
 		invalidInputFilePath := "nonexistent.mp3"
-		err := CopyAudioSegment(invalidInputFilePath, 0, 0, 30, outputDir)
+		_, err := CopyAudioSegment(context.Background(), invalidInputFilePath, 0, 0, 30, outputDir, CutOptions{Backend: BACKEND_FFMPEG})
 		if err == nil {
 			t.Errorf("Expected error for non-existent input file, but no error was returned")
 		}
@@ -178,6 +297,8 @@ func TestCopyAudioSegment_NegativeCases(t *testing.T) {
 }
 
 func TestSegmentAudio(t *testing.T) {
+	requireFFmpeg(t)
+
 	inputDir := INPUT_DIR
 	outputBaseDir := OUTPUT_DIR
 	const segmentDuration = 30
@@ -191,23 +312,59 @@ func TestSegmentAudio(t *testing.T) {
 	}
 	defer os.RemoveAll(outputBaseDir)
 
-	errors := SegmentAudio(inputFilePath, segmentDuration, outputDir)
+	segments, err := SegmentAudio(context.Background(), inputFilePath, outputDir, SegmentOptions{
+		CutOptions:      CutOptions{Backend: BACKEND_FFMPEG},
+		SegmentDuration: segmentDuration,
+		HopDuration:     segmentDuration,
+	})
+	if err != nil {
+		t.Fatalf("Failed to segment audio: %v", err)
+	}
 
-	if len(errors) > 0 {
-		for _, err := range errors {
-			t.Errorf("Error while segmenting audio: %v", err)
-		}
+	for _, err := range SegmentErrors(segments) {
+		t.Errorf("Error while segmenting audio: %v", err)
 	}
 
 	probeOutput, _ := ffmpeg_go.Probe(inputFilePath)
 	duration, _ := ParseDuration(probeOutput)
 	expectedSegments := int(math.Ceil(duration / float64(segmentDuration)))
 
-	segmentDirs, _ := os.ReadDir(outputDir)
+	if len(segments) != expectedSegments {
+		t.Errorf("Expected %d segment records, got %d", expectedSegments, len(segments))
+	}
+
+	for i, segment := range segments {
+		if segment.Index != i || segment.Start != float64(i*segmentDuration) {
+			t.Errorf("Segment %d has index %d and start %v", i, segment.Index, segment.Start)
+		}
+		if _, err := os.Stat(segment.OutputPath); err != nil {
+			t.Errorf("Segment %d output %s is missing: %v", i, segment.OutputPath, err)
+		}
+	}
+
+	entries, _ := os.ReadDir(outputDir)
+	var segmentDirs []os.DirEntry
+	for _, entry := range entries {
+		if entry.IsDir() {
+			segmentDirs = append(segmentDirs, entry)
+		}
+	}
 	if len(segmentDirs) != expectedSegments {
 		t.Errorf("Expected %d segments, found %d directories", expectedSegments, len(segmentDirs))
 	}
 
+	jsonManifestPath, csvManifestPath := ManifestPaths(inputFilePath, outputDir)
+	manifest, err := ReadManifest(jsonManifestPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if len(manifest.Segments) != expectedSegments {
+		t.Errorf("Expected %d manifest entries, got %d", expectedSegments, len(manifest.Segments))
+	}
+	if _, err := os.Stat(csvManifestPath); err != nil {
+		t.Errorf("CSV manifest is missing: %v", err)
+	}
+
 	for i, dir := range segmentDirs {
 		if !dir.IsDir() {
 			continue // Skip non-directory files, if any
@@ -233,3 +390,279 @@ func TestSegmentAudio(t *testing.T) {
 func fileNameWithoutExtension(fp string) string {
 	return strings.TrimSuffix(filepath.Base(fp), filepath.Ext(fp))
 }
+
+func TestCopySegments_CancelledContext(t *testing.T) {
+	outputDir := OUTPUT_DIR
+	defer os.RemoveAll(outputDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	boundaries := []SegmentBoundary{
+		{Index: 0, Start: 0, End: 30},
+		{Index: 1, Start: 30, End: 60},
+		{Index: 2, Start: 60, End: 90},
+	}
+
+	segments := copySegments(ctx, filepath.Join(INPUT_DIR, FILE_NAME), boundaries, outputDir, SegmentOptions{Concurrency: 2}, nil)
+
+	if len(segments) != len(boundaries) {
+		t.Fatalf("Expected %d segments, got %d", len(boundaries), len(segments))
+	}
+
+	for i, segment := range segments {
+		if segment.Index != boundaries[i].Index || segment.Start != boundaries[i].Start || segment.RequestedDuration != boundaries[i].Duration() {
+			t.Errorf("Segment %d does not describe its boundary: %+v", i, segment)
+		}
+		if !errors.Is(segment.Err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", segment.Err)
+		}
+	}
+}
+
+func TestSegmentStarts_FractionalHopDoesNotDrift(t *testing.T) {
+	starts := SegmentStarts(3600, 0.5, 0.1)
+
+	last := starts[len(starts)-1]
+	if want := 3599.5; math.Abs(last-want) > 1e-9 {
+		t.Errorf("Last start = %v, want %v", last, want)
+	}
+	if formatSeconds(starts[3]) != "0.3" {
+		t.Errorf("formatSeconds(%v) = %q, want %q", starts[3], formatSeconds(starts[3]), "0.3")
+	}
+}
+
+func TestSegmentAudio_RejectsUnboundedWindows(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 60)
+
+	for _, opts := range []SegmentOptions{
+		{SegmentDuration: math.NaN()},
+		{SegmentDuration: math.Inf(1)},
+		{SegmentDuration: 30, HopDuration: math.NaN()},
+		{SegmentDuration: 30, HopDuration: math.Inf(1)},
+		{SegmentDuration: 30, HopDuration: 1e-9},
+		{SegmentDuration: 1e-9},
+	} {
+		opts.Transcoder = transcoder
+		if _, err := SegmentAudio(context.Background(), inputFilePath, t.TempDir(), opts); err == nil {
+			t.Errorf("Expected a duration of %v and a hop of %v to be rejected", opts.SegmentDuration, opts.HopDuration)
+		}
+	}
+
+	if len(transcoder.cuts) != 0 {
+		t.Errorf("Cut %d segments with invalid options", len(transcoder.cuts))
+	}
+}
+
+func TestCutArgs(t *testing.T) {
+	inputArgs, outputArgs := cutArgs("in.mp3", 12.5, 30, CutOptions{})
+	if len(inputArgs) != 0 {
+		t.Errorf("Stream copy should not seek on the input, got %v", inputArgs)
+	}
+	if outputArgs["c"] != "copy" || outputArgs["ss"] != "12.5" || outputArgs["t"] != "30" {
+		t.Errorf("Unexpected stream copy output args: %v", outputArgs)
+	}
+
+	inputArgs, outputArgs = cutArgs("in.mp3", 12.5, 30, CutOptions{Accurate: true})
+	if inputArgs["ss"] != "12.5" {
+		t.Errorf("Accurate cut should seek on the input, got %v", inputArgs)
+	}
+	if _, ok := outputArgs["c"]; ok {
+		t.Errorf("Accurate cut should re-encode, got %v", outputArgs)
+	}
+	if outputArgs["t"] != "30" {
+		t.Errorf("Unexpected accurate output args: %v", outputArgs)
+	}
+
+	_, outputArgs = cutArgs("in.mp3", 12.5, 30, CutOptions{Output: OutputSpec{Format: FORMAT_WAV, SampleRate: 44100, Channels: 1}})
+	if _, ok := outputArgs["c"]; ok {
+		t.Errorf("Changing the output format should re-encode, got %v", outputArgs)
+	}
+	if outputArgs["c:a"] != "pcm_s16le" || outputArgs["ar"] != "44100" || outputArgs["ac"] != "1" || outputArgs["ss"] != "12.5" {
+		t.Errorf("Unexpected transcoding output args: %v", outputArgs)
+	}
+
+	_, outputArgs = cutArgs("in.mp3", 60, 30, CutOptions{Pad: true})
+	if _, ok := outputArgs["c"]; ok || outputArgs["af"] != "apad" || outputArgs["t"] != "30" {
+		t.Errorf("Padding should re-encode through apad, got %v", outputArgs)
+	}
+
+	_, outputArgs = cutArgs("in.mp3", 60, 30, CutOptions{Pad: true, GainDB: -4.5})
+	if _, ok := outputArgs["c"]; ok || outputArgs["af"] != "volume=-4.5dB,apad" {
+		t.Errorf("Gain should re-encode through volume before apad, got %v", outputArgs)
+	}
+}
+
+func TestCopyAudioSegment_Accurate(t *testing.T) {
+	requireFFmpeg(t)
+
+	outputDir := OUTPUT_DIR
+	os.MkdirAll(outputDir, 0755)
+	defer os.RemoveAll(outputDir)
+
+	inputFilePath := filepath.Join(INPUT_DIR, FILE_NAME)
+	filenameWithoutExt := fileNameWithoutExtension(FILE_NAME)
+	const segmentStart = 12.345
+	const segmentDuration = 7.5
+
+	_, err := CopyAudioSegment(context.Background(), inputFilePath, 0, segmentStart, segmentDuration, outputDir, CutOptions{Accurate: true, Backend: BACKEND_FFMPEG})
+	if err != nil {
+		t.Fatalf("Failed to copy audio segment: %v", err)
+	}
+
+	outputFilePath := filepath.Join(outputDir, fmt.Sprintf("%s_seg_0", filenameWithoutExt), fmt.Sprintf("%s_seg_0.mp3", filenameWithoutExt))
+	probeOutput, err := ffmpeg_go.Probe(outputFilePath)
+	if err != nil {
+		t.Fatalf("Failed to probe output file: %v", err)
+	}
+
+	duration, err := ParseDuration(probeOutput)
+	if err != nil {
+		t.Fatalf("Failed to parse duration from probe output: %v", err)
+	}
+
+	if math.Abs(duration-segmentDuration) > 0.01 {
+		t.Errorf("Segment duration is incorrect: got %v seconds, want %v seconds", duration, segmentDuration)
+	}
+}
+
+func TestSegmentAudio_FakeTranscoder(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	transcoder.durations["concert.mp3"] = 95
+	transcoder.cutErrs[30] = errors.New("corrupt frame")
+	outputDir := t.TempDir()
+
+	segments, err := SegmentAudio(context.Background(), "concert.mp3", outputDir, SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 30,
+	})
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	wantStarts := []float64{0, 30, 60, 90}
+	wantDurations := []float64{30, 0, 30, 5}
+	if len(segments) != len(wantStarts) {
+		t.Fatalf("SegmentAudio() returned %d segments, want %d", len(segments), len(wantStarts))
+	}
+
+	for i, segment := range segments {
+		if segment.Index != i || segment.Start != wantStarts[i] || segment.RequestedDuration != 30 {
+			t.Errorf("Segment %d = %+v", i, segment)
+		}
+		if segment.ActualDuration != wantDurations[i] {
+			t.Errorf("Segment %d duration = %v, want %v", i, segment.ActualDuration, wantDurations[i])
+		}
+		wantPath := filepath.Join(outputDir, fmt.Sprintf("concert_seg_%d", i), fmt.Sprintf("concert_seg_%d.mp3", i))
+		if segment.OutputPath != wantPath {
+			t.Errorf("Segment %d output path = %s, want %s", i, segment.OutputPath, wantPath)
+		}
+	}
+
+	errs := SegmentErrors(segments)
+	if len(errs) != 1 || segments[1].Err == nil {
+		t.Errorf("Expected only segment 1 to fail, got %v", errs)
+	}
+
+	jsonManifestPath, _ := ManifestPaths("concert.mp3", outputDir)
+	manifest, err := ReadManifest(jsonManifestPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.Options.Backend != "fake" {
+		t.Errorf("Manifest backend = %q, want the transcoder name", manifest.Options.Backend)
+	}
+}
+
+func TestSegmentAudio_ProbeError(t *testing.T) {
+	segments, err := SegmentAudio(context.Background(), "missing.mp3", t.TempDir(), SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: newFakeTranscoder()},
+		SegmentDuration: 30,
+	})
+
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("SegmentAudio() error = %v, want os.ErrNotExist", err)
+	}
+	if segments != nil {
+		t.Errorf("SegmentAudio() returned segments for an unreadable source: %v", segments)
+	}
+}
+
+func TestSegmentAudio_ConcurrencyLimit(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	transcoder.durations["concert.mp3"] = 100
+	transcoder.cutDelay = 10 * time.Millisecond
+
+	segments, err := SegmentAudio(context.Background(), "concert.mp3", t.TempDir(), SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 10,
+		HopDuration:     5,
+		Concurrency:     3,
+	})
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	if len(transcoder.cuts) != len(segments) {
+		t.Errorf("Transcoder ran %d cuts for %d segments", len(transcoder.cuts), len(segments))
+	}
+	if transcoder.maxActive > 3 {
+		t.Errorf("Ran %d cuts at once, want at most 3", transcoder.maxActive)
+	}
+}
+
+func TestSegmentAudio_Cancel(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	transcoder.durations["concert.mp3"] = 60
+	transcoder.blockAt[0] = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	segments, err := SegmentAudio(ctx, "concert.mp3", t.TempDir(), SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 10,
+		Concurrency:     1,
+	})
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	for _, segment := range segments {
+		if !errors.Is(segment.Err, context.Canceled) {
+			t.Errorf("Segment %d error = %v, want context.Canceled", segment.Index, segment.Err)
+		}
+	}
+	if len(transcoder.cuts) != 1 {
+		t.Errorf("Started %d cuts after cancellation, want only the blocked one", len(transcoder.cuts))
+	}
+}
+
+func TestSegmentAudio_Progress(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 95)
+
+	var events []progress.Event
+	segments, err := SegmentAudio(context.Background(), inputFilePath, t.TempDir(), SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 30,
+		Concurrency:     2,
+		Progress: func(event progress.Event) {
+			events = append(events, event)
+		},
+	})
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	// One event when the run starts and one per segment.
+	if len(events) != len(segments)+1 {
+		t.Fatalf("Got %d progress events for %d segments", len(events), len(segments))
+	}
+	for i, event := range events {
+		if event.Completed != i || event.Total != len(segments) || event.Current != inputFilePath {
+			t.Errorf("Event %d = %+v", i, event)
+		}
+	}
+}
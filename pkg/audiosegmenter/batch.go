@@ -0,0 +1,287 @@
+package audiosegmenter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"raga-recog-pipeline/pkg/progress"
+)
+
+// DEFAULT_AUDIO_EXTENSIONS are the files SegmentDirectory picks up when no
+// extensions are given.
+var DEFAULT_AUDIO_EXTENSIONS = []string{".mp3", ".wav", ".flac", ".m4a", ".ogg", ".opus", ".aac"}
+
+type BatchOptions struct {
+	SegmentOptions
+	// Extensions selects the files to segment, matched case-insensitively
+	// with or without the leading dot. Empty uses DEFAULT_AUDIO_EXTENSIONS.
+	Extensions []string
+}
+
+// FileResult is the outcome of segmenting one file of a batch.
+type FileResult struct {
+	InputPath string
+	OutputDir string
+	Segments  []Segment
+	// Err is set when the file could not be segmented at all.
+	Err error
+}
+
+// Failed reports whether the file or any of its segments failed.
+func (r FileResult) Failed() bool {
+	return r.Err != nil || len(SegmentErrors(r.Segments)) > 0
+}
+
+// Error returns the file error, or the errors of its failed segments.
+func (r FileResult) Error() error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return errors.Join(SegmentErrors(r.Segments)...)
+}
+
+// BatchSummary reports every file of a batch in path order.
+type BatchSummary struct {
+	Files     []FileResult
+	Succeeded int
+	Failed    int
+}
+
+// Write prints one line per file followed by the totals.
+func (s BatchSummary) Write(w io.Writer) error {
+	for _, file := range s.Files {
+		var err error
+		if file.Failed() {
+			_, err = fmt.Fprintf(w, "FAIL %s: %v\n", file.InputPath, strings.ReplaceAll(file.Error().Error(), "\n", "; "))
+		} else {
+			_, err = fmt.Fprintf(w, "ok   %s: %d segments\n", file.InputPath, len(file.Segments))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d files, %d succeeded, %d failed\n", len(s.Files), s.Succeeded, s.Failed)
+	return err
+}
+
+// FindAudioFiles walks inputDir and returns every regular file with one of
+// extensions in path order. Hidden files and directories are skipped.
+func FindAudioFiles(inputDir string, extensions []string) ([]string, error) {
+	if len(extensions) == 0 {
+		extensions = DEFAULT_AUDIO_EXTENSIONS
+	}
+
+	wanted := map[string]bool{}
+	for _, ext := range extensions {
+		wanted["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+
+	var files []string
+	err := filepath.WalkDir(inputDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path != inputDir && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if entry.Type().IsRegular() && wanted[strings.ToLower(filepath.Ext(path))] {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", inputDir, err)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// SegmentDirectory segments every audio file under inputDir with
+// SegmentAudio. The segments and manifest of inputDir/a/b.mp3 are written to
+// outputDir/a, mirroring the input tree, and an outputDir nested in inputDir
+// is not searched. Files are processed concurrently, but all of them share
+// the opts.Concurrency budget of cuts. opts.Progress counts the files of the
+// batch rather than their segments.
+//
+// A file that fails does not stop the batch: it is recorded in the summary,
+// which covers every file found. Files of one directory that differ only in
+// their extension, such as b.mp3 and b.wav, would write the same segments
+// and manifest, so all of them fail without being cut. The error is only set
+// when inputDir could not be searched, outputDir is inputDir or opts are
+// invalid.
+func SegmentDirectory(ctx context.Context, inputDir string, outputDir string, opts BatchOptions) (BatchSummary, error) {
+	segmentOpts, err := opts.SegmentOptions.resolve(true)
+	if err != nil {
+		return BatchSummary{}, err
+	}
+
+	files, err := FindAudioFiles(inputDir, opts.Extensions)
+	if err != nil {
+		return BatchSummary{}, err
+	}
+
+	files, err = excludeOutputDir(files, inputDir, outputDir)
+	if err != nil {
+		return BatchSummary{}, err
+	}
+
+	concurrency := segmentOpts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DEFAULT_CONCURRENCY
+	}
+	segmentOpts.slots = make(chan struct{}, concurrency)
+
+	tracker := progress.NewTracker(segmentOpts.Progress, len(files))
+	segmentOpts.Progress = nil
+
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	results := make([]FileResult, len(files))
+	collisions := findStemCollisions(files)
+
+	for w := 0; w < min(concurrency, len(files)); w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				tracker.Start(files[i])
+				results[i] = segmentFile(ctx, inputDir, files[i], outputDir, segmentOpts)
+				tracker.Done(files[i])
+			}
+		}()
+	}
+
+	for i := range files {
+		if err := collisions[i]; err != nil {
+			tracker.Start(files[i])
+			results[i] = FileResult{InputPath: files[i], Err: err}
+			tracker.Done(files[i])
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+
+	wg.Wait()
+
+	summary := BatchSummary{Files: results}
+	for _, result := range results {
+		if result.Failed() {
+			summary.Failed++
+		} else {
+			summary.Succeeded++
+		}
+	}
+
+	return summary, nil
+}
+
+func segmentFile(ctx context.Context, inputDir string, inputFilePath string, outputDir string, opts SegmentOptions) FileResult {
+	result := FileResult{InputPath: inputFilePath}
+
+	rel, err := filepath.Rel(inputDir, filepath.Dir(inputFilePath))
+	if err != nil {
+		result.Err = fmt.Errorf("failed to mirror %s into %s: %w", inputFilePath, outputDir, err)
+		return result
+	}
+	result.OutputDir = filepath.Join(outputDir, rel)
+
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Segments, err = SegmentAudio(ctx, inputFilePath, result.OutputDir, opts)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to segment %s: %w", inputFilePath, err)
+	}
+
+	return result
+}
+
+// findStemCollisions returns an error for every file that shares its
+// directory and name without extension with another file, keyed by the
+// index of the file. Names are compared case-insensitively so that the
+// batch behaves the same on case-insensitive file systems.
+func findStemCollisions(files []string) map[int]error {
+	byStem := map[string][]int{}
+	for i, file := range files {
+		base := filepath.Base(file)
+		stem := strings.ToLower(strings.TrimSuffix(base, filepath.Ext(base)))
+		key := filepath.Join(filepath.Dir(file), stem)
+		byStem[key] = append(byStem[key], i)
+	}
+
+	collisions := map[int]error{}
+	for _, indices := range byStem {
+		if len(indices) < 2 {
+			continue
+		}
+
+		names := make([]string, len(indices))
+		for j, i := range indices {
+			names[j] = filepath.Base(files[i])
+		}
+		for _, i := range indices {
+			collisions[i] = fmt.Errorf("%s would write the same segments and manifest as %s; rename one of them", files[i], strings.Join(names, ", "))
+		}
+	}
+
+	return collisions
+}
+
+// excludeOutputDir drops the files under outputDir when it is nested in
+// inputDir, so that the output of an earlier batch written inside the input
+// tree is not segmented again. Writing into inputDir itself is rejected, as
+// the segments could not then be told apart from the recordings.
+func excludeOutputDir(files []string, inputDir string, outputDir string) ([]string, error) {
+	absInput, err := filepath.Abs(inputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", inputDir, err)
+	}
+	absOutput, err := filepath.Abs(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", outputDir, err)
+	}
+
+	rel, err := filepath.Rel(absInput, absOutput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s against %s: %w", outputDir, inputDir, err)
+	}
+	if rel == "." {
+		return nil, fmt.Errorf("output directory %s must differ from the input directory", outputDir)
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return files, nil
+	}
+
+	kept := files[:0]
+	for _, file := range files {
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", file, err)
+		}
+		if !strings.HasPrefix(absFile, absOutput+string(os.PathSeparator)) {
+			kept = append(kept, file)
+		}
+	}
+
+	return kept, nil
+}
@@ -0,0 +1,241 @@
+package audiosegmenter
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"raga-recog-pipeline/pkg/progress"
+)
+
+// writeCollection lays out a collection with one directory per artist and
+// registers the durations of its recordings with transcoder.
+func writeCollection(t *testing.T, transcoder *fakeTranscoder, files map[string]float64) string {
+	t.Helper()
+
+	inputDir := t.TempDir()
+	for name, duration := range files {
+		path := filepath.Join(inputDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		if duration > 0 {
+			transcoder.durations[path] = duration
+		}
+	}
+
+	return inputDir
+}
+
+func TestFindAudioFiles(t *testing.T) {
+	inputDir := writeCollection(t, newFakeTranscoder(), map[string]float64{
+		"semmangudi/todi.mp3":           1,
+		"semmangudi/notes.txt":          1,
+		"ms_subbulakshmi/live/kapi.WAV": 1,
+		".trash/bhairavi.mp3":           1,
+		"ms_subbulakshmi/.kalyani.mp3":  1,
+	})
+
+	files, err := FindAudioFiles(inputDir, nil)
+	if err != nil {
+		t.Fatalf("FindAudioFiles() error = %v", err)
+	}
+
+	want := []string{
+		filepath.Join(inputDir, "ms_subbulakshmi/live/kapi.WAV"),
+		filepath.Join(inputDir, "semmangudi/todi.mp3"),
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("FindAudioFiles() = %v, want %v", files, want)
+	}
+
+	files, err = FindAudioFiles(inputDir, []string{"txt"})
+	if err != nil || len(files) != 1 || filepath.Base(files[0]) != "notes.txt" {
+		t.Errorf("FindAudioFiles() with extension filter = %v, %v", files, err)
+	}
+}
+
+func TestSegmentDirectory(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	transcoder.cutDelay = 5 * time.Millisecond
+	inputDir := writeCollection(t, transcoder, map[string]float64{
+		"semmangudi/todi.mp3":           60,
+		"semmangudi/bhairavi.mp3":       0,
+		"ms_subbulakshmi/live/kapi.wav": 45,
+	})
+	outputDir := filepath.Join(inputDir, "segments")
+
+	var last progress.Event
+	summary, err := SegmentDirectory(context.Background(), inputDir, outputDir, BatchOptions{
+		SegmentOptions: SegmentOptions{
+			CutOptions:      CutOptions{Transcoder: transcoder},
+			SegmentDuration: 30,
+			Concurrency:     2,
+			Progress:        func(event progress.Event) { last = event },
+		},
+	})
+	if err != nil {
+		t.Fatalf("SegmentDirectory() error = %v", err)
+	}
+
+	if len(summary.Files) != 3 || summary.Succeeded != 2 || summary.Failed != 1 {
+		t.Fatalf("SegmentDirectory() summary = %+v", summary)
+	}
+
+	// The source that cannot be probed fails on its own.
+	if failed := summary.Files[1]; !failed.Failed() || filepath.Base(failed.InputPath) != "bhairavi.mp3" {
+		t.Errorf("Expected bhairavi.mp3 to fail, got %+v", failed)
+	}
+
+	for _, path := range []string{
+		"semmangudi/todi_seg_1/todi_seg_1.mp3",
+		"semmangudi/todi" + MANIFEST_JSON_SUFFIX,
+		"ms_subbulakshmi/live/kapi_seg_1/kapi_seg_1.wav",
+	} {
+		if _, err := os.Stat(filepath.Join(outputDir, path)); err != nil {
+			t.Errorf("Expected %s in the mirrored output tree: %v", path, err)
+		}
+	}
+
+	if last.Completed != 3 || last.Total != 3 {
+		t.Errorf("Last progress event = %+v, want every file completed", last)
+	}
+
+	if transcoder.maxActive > 2 {
+		t.Errorf("Ran %d cuts at once across files, want at most 2", transcoder.maxActive)
+	}
+
+	var buf bytes.Buffer
+	if err := summary.Write(&buf); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "FAIL "+summary.Files[1].InputPath) || !strings.HasSuffix(buf.String(), "3 files, 2 succeeded, 1 failed\n") {
+		t.Errorf("Unexpected summary:\n%s", buf.String())
+	}
+
+	// A second batch does not pick up the segments written inside the input.
+	summary, err = SegmentDirectory(context.Background(), inputDir, outputDir, BatchOptions{
+		SegmentOptions: SegmentOptions{CutOptions: CutOptions{Transcoder: transcoder}, SegmentDuration: 30},
+	})
+	if err != nil || len(summary.Files) != 3 {
+		t.Errorf("Second SegmentDirectory() = %+v, %v", summary, err)
+	}
+}
+
+func TestSegmentDirectory_LoudnessSharesBudget(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	transcoder.cutDelay = 5 * time.Millisecond
+	inputDir := writeCollection(t, transcoder, map[string]float64{
+		"todi.mp3":     60,
+		"bhairavi.mp3": 60,
+		"kapi.mp3":     60,
+		"kalyani.mp3":  60,
+	})
+
+	summary, err := SegmentDirectory(context.Background(), inputDir, t.TempDir(), BatchOptions{
+		SegmentOptions: SegmentOptions{
+			CutOptions:      CutOptions{Transcoder: transcoder},
+			SegmentDuration: 30,
+			Concurrency:     2,
+			Loudness:        &LoudnessOptions{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SegmentDirectory() error = %v", err)
+	}
+	if summary.Failed != 0 {
+		t.Fatalf("SegmentDirectory() summary = %+v", summary)
+	}
+
+	if transcoder.measures != 4 {
+		t.Errorf("Measured loudness %d times, want once per file", transcoder.measures)
+	}
+	if transcoder.maxActive > 2 {
+		t.Errorf("Ran %d measurements and cuts at once across files, want at most 2", transcoder.maxActive)
+	}
+}
+
+func TestSegmentDirectory_OutputDir(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputDir := writeCollection(t, transcoder, map[string]float64{
+		"carnatic/todi.mp3": 60,
+	})
+	opts := BatchOptions{
+		SegmentOptions: SegmentOptions{CutOptions: CutOptions{Transcoder: transcoder}, SegmentDuration: 30},
+	}
+
+	if _, err := SegmentDirectory(context.Background(), inputDir, inputDir, opts); err == nil {
+		t.Error("Expected an error writing the segments into the input directory")
+	}
+
+	// An output directory holding the input tree keeps every file.
+	summary, err := SegmentDirectory(context.Background(), filepath.Join(inputDir, "carnatic"), inputDir, opts)
+	if err != nil || len(summary.Files) != 1 || summary.Failed != 0 {
+		t.Errorf("SegmentDirectory() into the parent = %+v, %v", summary, err)
+	}
+}
+
+func TestSegmentDirectory_StemCollision(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputDir := writeCollection(t, transcoder, map[string]float64{
+		"semmangudi/todi.mp3":      60,
+		"semmangudi/todi.wav":      60,
+		"semmangudi/kapi.mp3":      60,
+		"ms_subbulakshmi/todi.mp3": 60,
+	})
+	outputDir := t.TempDir()
+
+	summary, err := SegmentDirectory(context.Background(), inputDir, outputDir, BatchOptions{
+		SegmentOptions: SegmentOptions{CutOptions: CutOptions{Transcoder: transcoder}, SegmentDuration: 30},
+	})
+	if err != nil {
+		t.Fatalf("SegmentDirectory() error = %v", err)
+	}
+	if len(summary.Files) != 4 || summary.Succeeded != 2 || summary.Failed != 2 {
+		t.Fatalf("SegmentDirectory() summary = %+v", summary)
+	}
+
+	for _, file := range summary.Files {
+		colliding := filepath.Base(filepath.Dir(file.InputPath)) == "semmangudi" && strings.HasPrefix(filepath.Base(file.InputPath), "todi")
+		if file.Failed() != colliding {
+			t.Errorf("%s failed = %v, want %v: %v", file.InputPath, file.Failed(), colliding, file.Err)
+		}
+		if colliding && len(file.Segments) != 0 {
+			t.Errorf("%s was cut despite colliding", file.InputPath)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, "semmangudi", "todi"+MANIFEST_JSON_SUFFIX)); !os.IsNotExist(err) {
+		t.Errorf("Expected no manifest for the colliding recordings, got %v", err)
+	}
+}
+
+func TestSegmentDirectory_TemplateWithoutName(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputDir := writeCollection(t, transcoder, map[string]float64{
+		"a.mp3": 60,
+		"b.mp3": 60,
+	})
+
+	// Every recording would write 0.mp3 and 1.mp3 side by side.
+	_, err := SegmentDirectory(context.Background(), inputDir, t.TempDir(), BatchOptions{
+		SegmentOptions: SegmentOptions{
+			CutOptions:      CutOptions{Transcoder: transcoder, NameTemplate: "{index}.{ext}", Layout: LAYOUT_FLAT},
+			SegmentDuration: 30,
+		},
+	})
+	if err == nil {
+		t.Error("Expected a name template without {name} to be rejected")
+	}
+	if len(transcoder.cuts) != 0 {
+		t.Errorf("Cut %d segments with a colliding name template", len(transcoder.cuts))
+	}
+}
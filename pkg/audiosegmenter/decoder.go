@@ -0,0 +1,256 @@
+package audiosegmenter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// PCM is decoded audio as interleaved 16-bit samples.
+type PCM struct {
+	Samples    []int16
+	SampleRate int
+	Channels   int
+}
+
+func (p PCM) Frames() int {
+	return len(p.Samples) / p.Channels
+}
+
+func (p PCM) Duration() float64 {
+	return float64(p.Frames()) / float64(p.SampleRate)
+}
+
+// PCMStream decodes a WAV or MP3 file on demand without the ffmpeg binary.
+// Reads and seeks are in interleaved 16-bit little-endian samples.
+type PCMStream struct {
+	reader   io.ReadSeeker
+	closer   io.Closer
+	length   int64
+	format   string
+	codec    string
+	bitDepth int
+	// sourceChannels is the number of channels coded in the file, which for
+	// a mono MP3 is fewer than the decoder puts out.
+	sourceChannels int
+	SampleRate     int
+	Channels       int
+}
+
+// OpenPCM opens inputFilePath with the pure-Go decoder matching its
+// extension. Only WAV and MP3 are supported; MP3 is always decoded to stereo,
+// with the channel of a mono file repeated in both.
+func OpenPCM(inputFilePath string) (*PCMStream, error) {
+	file, err := os.Open(inputFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", inputFilePath, err)
+	}
+
+	stream := &PCMStream{closer: file}
+
+	switch ext := strings.ToLower(filepath.Ext(inputFilePath)); ext {
+	case ".wav", ".wave":
+		reader, err := newWAVPCMReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to decode %s: %w", inputFilePath, err)
+		}
+		if reader.header.dataSize < 0 {
+			// Written to a pipe, so the data runs to the end of the file.
+			if info, err := file.Stat(); err == nil {
+				reader.header.dataSize = info.Size() - reader.header.dataOffset
+			}
+		}
+		stream.reader = reader
+		stream.length = reader.Length()
+		stream.SampleRate = reader.header.sampleRate
+		stream.Channels = reader.header.channels
+		stream.sourceChannels = reader.header.channels
+		stream.format = "wav"
+		stream.codec = reader.header.codec()
+		stream.bitDepth = reader.header.bitsPerSample
+
+	case ".mp3":
+		stream.sourceChannels = readMP3Channels(bufio.NewReader(file))
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to decode %s: %w", inputFilePath, err)
+		}
+
+		decoder, err := mp3.NewDecoder(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to decode %s: %w", inputFilePath, err)
+		}
+		stream.reader = decoder
+		stream.length = decoder.Length()
+		stream.SampleRate = decoder.SampleRate()
+		stream.Channels = 2
+		stream.format = "mp3"
+		stream.codec = "mp3"
+
+	default:
+		file.Close()
+		return nil, fmt.Errorf("no pure-Go decoder for %q files", ext)
+	}
+
+	return stream, nil
+}
+
+// readMP3Channels returns the number of channels of the first MPEG audio
+// frame of r, skipping an ID3v2 tag in front of it. When no frame is found it
+// leaves the decoder to report the error and assumes stereo.
+func readMP3Channels(r *bufio.Reader) int {
+	if header, err := r.Peek(10); err == nil && string(header[0:3]) == "ID3" {
+		size := 10 + syncsafe(header[6:10])
+		if header[5]&0x10 != 0 {
+			size += 10
+		}
+		if _, err := r.Discard(size); err != nil {
+			return 2
+		}
+	}
+
+	// Some encoders leave junk in front of the first frame, so look for a
+	// valid header a little way in.
+	for skipped := 0; skipped < 64*1024; skipped++ {
+		header, err := r.Peek(4)
+		if err != nil {
+			break
+		}
+		version, layer := (header[1]>>3)&3, (header[1]>>1)&3
+		bitrate, sampleRate := header[2]>>4, (header[2]>>2)&3
+		if header[0] == 0xff && header[1]&0xe0 == 0xe0 && version != 1 && layer != 0 && bitrate != 15 && sampleRate != 3 {
+			// Channel mode 3 is a single channel.
+			if header[3]>>6 == 3 {
+				return 1
+			}
+			return 2
+		}
+		if _, err := r.Discard(1); err != nil {
+			break
+		}
+	}
+
+	return 2
+}
+
+func (s *PCMStream) Close() error {
+	return s.closer.Close()
+}
+
+func (s *PCMStream) frameSize() int64 {
+	return int64(2 * s.Channels)
+}
+
+func (s *PCMStream) Frames() int64 {
+	return s.length / s.frameSize()
+}
+
+func (s *PCMStream) Duration() float64 {
+	return float64(s.Frames()) / float64(s.SampleRate)
+}
+
+// ReadRange decodes duration seconds of audio starting at start seconds. The
+// range is clamped to the end of the stream and rounded to whole frames.
+func (s *PCMStream) ReadRange(start float64, duration float64) (PCM, error) {
+	pcm := PCM{SampleRate: s.SampleRate, Channels: s.Channels}
+
+	firstFrame := min(int64(math.Round(start*float64(s.SampleRate))), s.Frames())
+	lastFrame := min(int64(math.Round((start+duration)*float64(s.SampleRate))), s.Frames())
+	if firstFrame < 0 || lastFrame < firstFrame {
+		return pcm, fmt.Errorf("invalid range %v+%v seconds", start, duration)
+	}
+
+	if firstFrame == lastFrame {
+		return pcm, nil
+	}
+
+	if _, err := s.reader.Seek(firstFrame*s.frameSize(), io.SeekStart); err != nil {
+		return pcm, fmt.Errorf("failed to seek to %v seconds: %w", start, err)
+	}
+
+	raw := make([]byte, (lastFrame-firstFrame)*s.frameSize())
+	n, err := io.ReadFull(s.reader, raw)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return pcm, fmt.Errorf("failed to decode range: %w", err)
+	}
+
+	pcm.Samples = bytesToSamples(raw[:n-n%int(s.frameSize())])
+	return pcm, nil
+}
+
+// ReadAll decodes the remainder of the stream from its current position.
+func (s *PCMStream) ReadAll() (PCM, error) {
+	raw, err := io.ReadAll(bufio.NewReader(s.reader))
+	if err != nil {
+		return PCM{}, fmt.Errorf("failed to decode stream: %w", err)
+	}
+
+	return PCM{
+		Samples:    bytesToSamples(raw[:len(raw)-len(raw)%int(s.frameSize())]),
+		SampleRate: s.SampleRate,
+		Channels:   s.Channels,
+	}, nil
+}
+
+func bytesToSamples(raw []byte) []int16 {
+	samples := make([]int16, len(raw)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(raw[i*2:]))
+	}
+	return samples
+}
+
+// DecodeFile decodes a whole WAV or MP3 file without the ffmpeg binary.
+func DecodeFile(inputFilePath string) (PCM, error) {
+	stream, err := OpenPCM(inputFilePath)
+	if err != nil {
+		return PCM{}, err
+	}
+	defer stream.Close()
+
+	return stream.ReadAll()
+}
+
+// ProbeDurationNative returns the duration of a WAV or MP3 file in seconds
+// without the ffprobe binary.
+func ProbeDurationNative(inputFilePath string) (float64, error) {
+	stream, err := OpenPCM(inputFilePath)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	return stream.Duration(), nil
+}
+
+// Downmix averages every frame of pcm down to a single channel.
+func Downmix(pcm PCM) PCM {
+	if pcm.Channels <= 1 {
+		return pcm
+	}
+
+	mono := PCM{
+		Samples:    make([]int16, pcm.Frames()),
+		SampleRate: pcm.SampleRate,
+		Channels:   1,
+	}
+
+	for i := range mono.Samples {
+		var sum int
+		for c := 0; c < pcm.Channels; c++ {
+			sum += int(pcm.Samples[i*pcm.Channels+c])
+		}
+		mono.Samples[i] = int16(sum / pcm.Channels)
+	}
+
+	return mono
+}
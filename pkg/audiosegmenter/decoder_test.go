@@ -0,0 +1,114 @@
+package audiosegmenter
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const NATIVE_SAMPLE_FILE_NAME = "01-kaligiyuNTEgadA_galgunu-kIravANi_seg_10.mp3"
+
+// writeTestWAV writes a stereo sine tone of the given length to dir and
+// returns its path.
+func writeTestWAV(t *testing.T, dir string, name string, seconds float64, sampleRate int, bitDepth int) string {
+	t.Helper()
+
+	frames := int(seconds * float64(sampleRate))
+	samples := make([]int16, frames*2)
+	for i := 0; i < frames; i++ {
+		v := int16(10000 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate)))
+		samples[i*2] = v
+		samples[i*2+1] = -v
+	}
+
+	var buf bytes.Buffer
+	if err := WriteWAV(&buf, samples, sampleRate, 2, bitDepth); err != nil {
+		t.Fatalf("WriteWAV() error = %v", err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test WAV: %v", err)
+	}
+
+	return path
+}
+
+func TestDecodeWAV(t *testing.T) {
+	for _, bitDepth := range []int{16, 24, 32} {
+		path := writeTestWAV(t, t.TempDir(), "tone.wav", 1.5, 8000, bitDepth)
+
+		pcm, err := DecodeFile(path)
+		if err != nil {
+			t.Fatalf("DecodeFile() with %d-bit WAV error = %v", bitDepth, err)
+		}
+
+		if pcm.SampleRate != 8000 || pcm.Channels != 2 {
+			t.Errorf("%d-bit WAV decoded as %d channels at %d Hz", bitDepth, pcm.Channels, pcm.SampleRate)
+		}
+		if pcm.Duration() != 1.5 {
+			t.Errorf("%d-bit WAV duration = %v, want 1.5", bitDepth, pcm.Duration())
+		}
+		if want := int16(10000 * math.Sin(2*math.Pi*440*3/8000)); pcm.Samples[6] != want || pcm.Samples[7] != -want {
+			t.Errorf("%d-bit WAV frame 3 = %v, want [%d %d]", bitDepth, pcm.Samples[6:8], want, -want)
+		}
+	}
+}
+
+func TestPCMStreamReadRange(t *testing.T) {
+	path := writeTestWAV(t, t.TempDir(), "tone.wav", 2, 8000, 16)
+
+	stream, err := OpenPCM(path)
+	if err != nil {
+		t.Fatalf("OpenPCM() error = %v", err)
+	}
+	defer stream.Close()
+
+	pcm, err := stream.ReadRange(0.5, 0.25)
+	if err != nil {
+		t.Fatalf("ReadRange() error = %v", err)
+	}
+	if pcm.Frames() != 2000 {
+		t.Errorf("ReadRange() returned %d frames, want 2000", pcm.Frames())
+	}
+	if want := int16(10000 * math.Sin(2*math.Pi*440*4000/8000)); pcm.Samples[0] != want {
+		t.Errorf("First sample = %d, want %d", pcm.Samples[0], want)
+	}
+
+	tail, err := stream.ReadRange(1.9, 1)
+	if err != nil {
+		t.Fatalf("ReadRange() past the end error = %v", err)
+	}
+	if tail.Frames() != 800 {
+		t.Errorf("ReadRange() past the end returned %d frames, want 800", tail.Frames())
+	}
+
+	mono := Downmix(pcm)
+	if mono.Channels != 1 || mono.Frames() != pcm.Frames() || mono.Samples[0] != 0 {
+		t.Errorf("Downmix() of opposite channels = %d channels, %d frames, first sample %d", mono.Channels, mono.Frames(), mono.Samples[0])
+	}
+}
+
+func TestProbeDurationNative_MP3(t *testing.T) {
+	duration, err := ProbeDurationNative(filepath.Join(INPUT_DIR, NATIVE_SAMPLE_FILE_NAME))
+	if err != nil {
+		t.Fatalf("ProbeDurationNative() error = %v", err)
+	}
+
+	if duration < 29 || duration > 31 {
+		t.Errorf("ProbeDurationNative() = %v, want around 30 seconds", duration)
+	}
+}
+
+func TestOpenPCM_UnsupportedFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "track.ogg")
+	if err := os.WriteFile(path, []byte("OggS"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	if _, err := OpenPCM(path); err == nil {
+		t.Errorf("Expected an error for an unsupported format")
+	}
+}
@@ -0,0 +1,249 @@
+package audiosegmenter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTranscoder is an in-memory Transcoder. Sources are registered with
+// their duration, cuts write a small text file holding the duration the
+// segment would have had, so segmentation can be tested without ffmpeg.
+type fakeTranscoder struct {
+	mu sync.Mutex
+
+	durations map[string]float64
+	pcm       map[string]PCM
+	metadata  map[string]RecordingMetadata
+	loudness  map[string]float64
+
+	// cutErrs fails the cut starting at the given offset.
+	cutErrs map[float64]error
+	// blockAt makes the cut starting at the given offset wait for its
+	// context to be cancelled.
+	blockAt map[float64]bool
+	// truncate makes the cut starting at the given offset come out this many
+	// seconds long, or empty when negative.
+	truncate map[float64]float64
+	// cutDelay is how long every cut and loudness measurement takes.
+	cutDelay time.Duration
+
+	cuts      []fakeCut
+	measures  int
+	encodes   int
+	active    int
+	maxActive int
+}
+
+type fakeCut struct {
+	inputFilePath  string
+	outputFilePath string
+	start          float64
+	duration       float64
+	cut            CutOptions
+}
+
+func newFakeTranscoder() *fakeTranscoder {
+	return &fakeTranscoder{
+		durations: map[string]float64{},
+		pcm:       map[string]PCM{},
+		metadata:  map[string]RecordingMetadata{},
+		loudness:  map[string]float64{},
+		cutErrs:   map[float64]error{},
+		blockAt:   map[float64]bool{},
+		truncate:  map[float64]float64{},
+	}
+}
+
+// addSource writes a source file for name with unique content and registers
+// its duration. Sources only registered in durations work too, but runs
+// only reuse segments of sources they can fingerprint on disk.
+func (f *fakeTranscoder) addSource(t *testing.T, name string, duration float64) string {
+	t.Helper()
+
+	inputFilePath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(inputFilePath, []byte(inputFilePath), 0644); err != nil {
+		t.Fatalf("Failed to write fake source: %v", err)
+	}
+
+	f.mu.Lock()
+	f.durations[inputFilePath] = duration
+	f.mu.Unlock()
+
+	return inputFilePath
+}
+
+func (f *fakeTranscoder) Name() string {
+	return "fake"
+}
+
+func (f *fakeTranscoder) ProbeDuration(ctx context.Context, inputFilePath string) (float64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if duration, ok := f.durations[inputFilePath]; ok {
+		return duration, nil
+	}
+
+	// Segments are renamed after they are cut, so their duration is read
+	// back from the file.
+	var source string
+	var start, duration float64
+	content, err := os.ReadFile(inputFilePath)
+	if err != nil {
+		return 0, fmt.Errorf("probe %s: %w", inputFilePath, err)
+	}
+	if _, err := fmt.Sscanf(string(content), "%s %v %v", &source, &start, &duration); err != nil {
+		return 0, fmt.Errorf("probe %s: %w", inputFilePath, err)
+	}
+	return duration, nil
+}
+
+func (f *fakeTranscoder) ProbeAudio(ctx context.Context, inputFilePath string) (AudioInfo, error) {
+	duration, err := f.ProbeDuration(ctx, inputFilePath)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	return AudioInfo{Codec: "pcm_s16le", SampleRate: 44100, Channels: 2, BitDepth: 16, Duration: duration}, nil
+}
+
+func (f *fakeTranscoder) ProbeMetadata(ctx context.Context, inputFilePath string) (RecordingMetadata, error) {
+	if _, err := f.ProbeDuration(ctx, inputFilePath); err != nil {
+		return RecordingMetadata{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.metadata[inputFilePath], nil
+}
+
+func (f *fakeTranscoder) MeasureLoudness(ctx context.Context, inputFilePath string) (float64, error) {
+	if _, err := f.ProbeDuration(ctx, inputFilePath); err != nil {
+		return 0, err
+	}
+
+	// Measuring decodes the whole source, so it is as busy as a cut.
+	f.mu.Lock()
+	f.measures++
+	f.active++
+	f.maxActive = max(f.maxActive, f.active)
+	loudness := f.loudness[inputFilePath]
+	f.mu.Unlock()
+
+	if f.cutDelay > 0 {
+		time.Sleep(f.cutDelay)
+	}
+
+	f.mu.Lock()
+	f.active--
+	f.mu.Unlock()
+
+	return loudness, nil
+}
+
+func (f *fakeTranscoder) ResolveOutput(spec OutputSpec) (OutputSpec, error) {
+	return spec, nil
+}
+
+func (f *fakeTranscoder) Cut(ctx context.Context, inputFilePath string, outputFilePath string, segmentStart float64, segmentDuration float64, cut CutOptions) error {
+	f.mu.Lock()
+	f.cuts = append(f.cuts, fakeCut{inputFilePath, outputFilePath, segmentStart, segmentDuration, cut})
+	f.active++
+	f.maxActive = max(f.maxActive, f.active)
+	sourceDuration, ok := f.durations[inputFilePath]
+	cutErr := f.cutErrs[segmentStart]
+	block := f.blockAt[segmentStart]
+	truncated, truncate := f.truncate[segmentStart]
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.active--
+		f.mu.Unlock()
+	}()
+
+	if !ok {
+		return fmt.Errorf("cut %s: %w", inputFilePath, os.ErrNotExist)
+	}
+	if block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if f.cutDelay > 0 {
+		time.Sleep(f.cutDelay)
+	}
+	if cutErr != nil {
+		return cutErr
+	}
+
+	actual := max(min(segmentDuration, sourceDuration-segmentStart), 0)
+	if cut.Pad {
+		actual = segmentDuration
+	}
+	if truncate {
+		if truncated < 0 {
+			return os.WriteFile(outputFilePath, nil, 0644)
+		}
+		actual = truncated
+	}
+	content := fmt.Sprintf("%s %v %v\n", inputFilePath, segmentStart, actual)
+	return os.WriteFile(outputFilePath, []byte(content), 0644)
+}
+
+func (f *fakeTranscoder) DecodeMono(ctx context.Context, inputFilePath string, sampleRate int) (PCM, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pcm, ok := f.pcm[inputFilePath]
+	if !ok {
+		return pcm, fmt.Errorf("decode %s: %w", inputFilePath, os.ErrNotExist)
+	}
+	return pcm, nil
+}
+
+// DecodeStream consumes r and streams the PCM registered for name.
+func (f *fakeTranscoder) DecodeStream(ctx context.Context, r io.Reader, name string) (*PCMReader, error) {
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pcm, ok := f.pcm[name]
+	if !ok {
+		return nil, fmt.Errorf("decode %s: %w", name, os.ErrNotExist)
+	}
+	return &PCMReader{
+		reader:     bytes.NewReader(samplesToBytes(pcm.Samples)),
+		SampleRate: pcm.SampleRate,
+		Channels:   pcm.Channels,
+	}, nil
+}
+
+func (f *fakeTranscoder) Encode(ctx context.Context, pcm PCM, outputFilePath string, cut CutOptions) error {
+	f.mu.Lock()
+	f.encodes++
+	f.active++
+	f.maxActive = max(f.maxActive, f.active)
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.active--
+		f.mu.Unlock()
+	}()
+
+	if f.cutDelay > 0 {
+		time.Sleep(f.cutDelay)
+	}
+
+	content := fmt.Sprintf("%s %v %v\n", outputFilePath, 0, pcm.Duration())
+	return os.WriteFile(outputFilePath, []byte(content), 0644)
+}
@@ -0,0 +1,152 @@
+package audiosegmenter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3FrameTags maps ID3v2 text frames to the tag names ffprobe reports for
+// them, so both backends produce the same metadata.
+var id3FrameTags = map[string]string{
+	"TALB": "album",
+	"TCOM": "composer",
+	"TCON": "genre",
+	"TDRC": "date",
+	"TIT1": "grouping",
+	"TIT2": "title",
+	"TLAN": "language",
+	"TPE1": "artist",
+	"TPE2": "album_artist",
+	"TPE3": "performer",
+	"TRCK": "track",
+	"TYER": "date",
+}
+
+// readID3Tags reads the text frames of an ID3v2.3 or ID3v2.4 tag at the start
+// of r. Files without a tag, or with an older version, have no tags.
+func readID3Tags(r io.Reader) (map[string]string, error) {
+	tags := map[string]string{}
+
+	var header [10]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return tags, nil
+		}
+		return tags, err
+	}
+	if string(header[0:3]) != "ID3" {
+		return tags, nil
+	}
+
+	version := header[3]
+	if version != 3 && version != 4 {
+		return tags, nil
+	}
+
+	body := make([]byte, syncsafe(header[6:10]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return tags, fmt.Errorf("failed to read ID3 tag: %w", err)
+	}
+
+	if header[5]&0x40 != 0 {
+		if len(body) < 4 {
+			return tags, errors.New("truncated ID3 extended header")
+		}
+		// The v2.3 extended header size excludes its own size field.
+		extended := int(binary.BigEndian.Uint32(body[0:4])) + 4
+		if version == 4 {
+			extended = syncsafe(body[0:4])
+		}
+		if extended > len(body) {
+			return tags, errors.New("truncated ID3 extended header")
+		}
+		body = body[extended:]
+	}
+
+	for len(body) >= 10 && body[0] != 0 {
+		id := string(body[0:4])
+		size := int(binary.BigEndian.Uint32(body[4:8]))
+		if version == 4 {
+			size = syncsafe(body[4:8])
+		}
+		if size > len(body)-10 {
+			return tags, fmt.Errorf("truncated ID3 frame %s", id)
+		}
+
+		frame := body[10 : 10+size]
+		body = body[10+size:]
+
+		if id == "TXXX" {
+			values := strings.SplitN(decodeID3Text(frame), "\x00", 2)
+			if len(values) == 2 && values[0] != "" {
+				tags[strings.ToLower(values[0])] = values[1]
+			}
+			continue
+		}
+
+		if key, ok := id3FrameTags[id]; ok {
+			// v2.4 separates multiple values with NUL; keep the first.
+			tags[key] = strings.SplitN(decodeID3Text(frame), "\x00", 2)[0]
+		}
+	}
+
+	return tags, nil
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// decodeID3Text decodes a text frame body after its encoding byte and drops
+// the trailing terminator.
+func decodeID3Text(frame []byte) string {
+	if len(frame) == 0 {
+		return ""
+	}
+
+	encoding, text := frame[0], frame[1:]
+	var decoded string
+
+	switch encoding {
+	case 0:
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+		}
+		decoded = string(runes)
+	case 1, 2:
+		decoded = decodeUTF16(text, encoding == 2)
+	default:
+		decoded = string(text)
+	}
+
+	return strings.TrimRight(decoded, "\x00")
+}
+
+// decodeUTF16 decodes UTF-16 text in which every string, including the
+// second one of a TXXX frame, may start with its own byte order mark.
+func decodeUTF16(text []byte, bigEndian bool) string {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+
+	units := make([]uint16, 0, len(text)/2)
+	for i := 0; i+1 < len(text); i += 2 {
+		switch {
+		case bytes.Equal(text[i:i+2], []byte{0xff, 0xfe}):
+			order = binary.LittleEndian
+		case bytes.Equal(text[i:i+2], []byte{0xfe, 0xff}):
+			order = binary.BigEndian
+		default:
+			units = append(units, order.Uint16(text[i:]))
+		}
+	}
+
+	return string(utf16.Decode(units))
+}
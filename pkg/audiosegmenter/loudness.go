@@ -0,0 +1,340 @@
+package audiosegmenter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// EBU_R128_TARGET_LUFS is the programme loudness EBU R128 recommends.
+	EBU_R128_TARGET_LUFS = -23.0
+	// LOUDNESS_ABSOLUTE_GATE is the level in LUFS below which blocks are left
+	// out of the integrated loudness. A source that is quieter throughout is
+	// reported at this level and left as it is.
+	LOUDNESS_ABSOLUTE_GATE = -70.0
+	// LOUDNESS_RELATIVE_GATE is how far below the loudness of the blocks
+	// above the absolute gate a block has to be to be left out as well.
+	LOUDNESS_RELATIVE_GATE = -10.0
+)
+
+// LoudnessOptions enables loudness normalization. The gain is measured over
+// the whole source and applied to every segment cut from it, so that the
+// dynamics between segments are kept.
+type LoudnessOptions struct {
+	// TargetLUFS is the integrated loudness sources are brought to. Zero uses
+	// EBU_R128_TARGET_LUFS.
+	TargetLUFS float64 `json:"target_lufs"`
+	// MaxGainDB limits how much quiet sources are boosted, since boosting
+	// also raises their noise floor and peaks clip. Zero leaves it unlimited.
+	MaxGainDB float64 `json:"max_gain_db,omitempty"`
+}
+
+func (opts *LoudnessOptions) Validate() error {
+	if opts == nil {
+		return nil
+	}
+	if !(opts.TargetLUFS <= 0) || math.IsInf(opts.TargetLUFS, -1) {
+		return fmt.Errorf("target loudness must be finite and not above 0 LUFS, got %v", opts.TargetLUFS)
+	}
+	if !(opts.MaxGainDB >= 0) || math.IsInf(opts.MaxGainDB, 1) {
+		return fmt.Errorf("maximum gain must be finite and not negative, got %v", opts.MaxGainDB)
+	}
+	return nil
+}
+
+// Loudness records the normalization of a source.
+type Loudness struct {
+	// MeasuredLUFS is the integrated loudness of the source.
+	MeasuredLUFS float64 `json:"measured_lufs"`
+	TargetLUFS   float64 `json:"target_lufs"`
+	// GainDB is the gain applied to every segment.
+	GainDB float64 `json:"gain_db"`
+}
+
+// NewLoudness works out the gain that brings a source measured at
+// measuredLUFS to the target of opts.
+func NewLoudness(measuredLUFS float64, opts LoudnessOptions) Loudness {
+	loudness := Loudness{MeasuredLUFS: measuredLUFS, TargetLUFS: opts.TargetLUFS}
+	if loudness.TargetLUFS == 0 {
+		loudness.TargetLUFS = EBU_R128_TARGET_LUFS
+	}
+
+	if measuredLUFS <= LOUDNESS_ABSOLUTE_GATE {
+		return loudness
+	}
+
+	loudness.GainDB = loudness.TargetLUFS - measuredLUFS
+	if opts.MaxGainDB > 0 {
+		loudness.GainDB = min(loudness.GainDB, opts.MaxGainDB)
+	}
+	// Rounded so that manifests and ffmpeg arguments stay readable.
+	loudness.GainDB = math.Round(loudness.GainDB*100) / 100
+
+	return loudness
+}
+
+// normalize measures the source of the run and returns opts with the gain
+// that brings it to the target loudness. The measurement of an earlier run
+// over the same source with the same parameters is reused.
+func (r *segmentRun) normalize(ctx context.Context, opts SegmentOptions) (SegmentOptions, error) {
+	if opts.Loudness == nil {
+		return opts, nil
+	}
+
+	loudness := r.previousLoudness
+	if loudness == nil {
+		// Measuring decodes the whole source, so it takes a slot like a cut.
+		release, err := opts.acquire(ctx)
+		if err != nil {
+			return opts, err
+		}
+		measured, err := opts.Transcoder.MeasureLoudness(ctx, r.inputFilePath)
+		release()
+		if err != nil {
+			return opts, fmt.Errorf("failed to measure the loudness of %s: %w", r.inputFilePath, err)
+		}
+
+		normalized := NewLoudness(measured, *opts.Loudness)
+		loudness = &normalized
+	}
+
+	r.loudness = loudness
+	opts.GainDB = loudness.GainDB
+
+	return opts, nil
+}
+
+// loudnessMeter measures integrated loudness as specified by ITU-R BS.1770-4:
+// K-weighted mean square power over 400 ms blocks overlapping by 75%, gated
+// at LOUDNESS_ABSOLUTE_GATE and then LOUDNESS_RELATIVE_GATE.
+type loudnessMeter struct {
+	channels []kWeighting
+	weights  []float64
+	// stepFrames is the 100 ms hop between blocks.
+	stepFrames int
+	frames     int
+	stepSum    []float64
+	// steps holds the channel weighted power of the last four steps.
+	steps  []float64
+	blocks []float64
+}
+
+func newLoudnessMeter(sampleRate int, channels int) *loudnessMeter {
+	meter := &loudnessMeter{
+		channels:   make([]kWeighting, channels),
+		weights:    make([]float64, channels),
+		stepFrames: max(sampleRate/10, 1),
+		stepSum:    make([]float64, channels),
+	}
+
+	for c := range meter.channels {
+		meter.channels[c] = newKWeighting(float64(sampleRate))
+		meter.weights[c] = 1
+		// In a 5.1 layout the LFE channel is left out and the surround
+		// channels count for 1.5 dB more.
+		if channels == 6 && c == 3 {
+			meter.weights[c] = 0
+		}
+		if channels == 6 && c >= 4 {
+			meter.weights[c] = 1.41
+		}
+	}
+
+	return meter
+}
+
+func (m *loudnessMeter) write(pcm PCM) {
+	channels := len(m.channels)
+
+	for i := 0; i+channels <= len(pcm.Samples); i += channels {
+		for c := range m.channels {
+			sample := m.channels[c].filter(float64(pcm.Samples[i+c]) / 32768)
+			m.stepSum[c] += sample * sample
+		}
+
+		m.frames++
+		if m.frames < m.stepFrames {
+			continue
+		}
+
+		power := 0.0
+		for c := range m.stepSum {
+			power += m.weights[c] * m.stepSum[c]
+			m.stepSum[c] = 0
+		}
+		m.frames = 0
+
+		m.steps = append(m.steps, power)
+		if len(m.steps) > 4 {
+			m.steps = m.steps[1:]
+		}
+		if len(m.steps) == 4 {
+			block := 0.0
+			for _, step := range m.steps {
+				block += step
+			}
+			m.blocks = append(m.blocks, block/float64(4*m.stepFrames))
+		}
+	}
+}
+
+func (m *loudnessMeter) integrated() float64 {
+	gated := func(threshold float64) (float64, int) {
+		sum, n := 0.0, 0
+		for _, power := range m.blocks {
+			if powerToLUFS(power) > threshold {
+				sum += power
+				n++
+			}
+		}
+		return sum, n
+	}
+
+	sum, n := gated(LOUDNESS_ABSOLUTE_GATE)
+	if n == 0 {
+		return LOUDNESS_ABSOLUTE_GATE
+	}
+
+	sum, n = gated(powerToLUFS(sum/float64(n)) + LOUDNESS_RELATIVE_GATE)
+	if n == 0 {
+		return LOUDNESS_ABSOLUTE_GATE
+	}
+
+	return powerToLUFS(sum / float64(n))
+}
+
+func powerToLUFS(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+// kWeighting is the two stage K-weighting filter: a high shelf modelling the
+// acoustic effect of the head followed by a high pass. The coefficients are
+// derived for the sample rate as in libebur128.
+type kWeighting struct {
+	shelf, highPass biquad
+}
+
+func newKWeighting(sampleRate float64) kWeighting {
+	const (
+		shelfFrequency = 1681.974450955533
+		shelfGainDB    = 3.999843853973347
+		shelfQ         = 0.7071752369554196
+		passFrequency  = 38.13547087602444
+		passQ          = 0.5003270373238773
+	)
+
+	k := math.Tan(math.Pi * shelfFrequency / sampleRate)
+	vh := math.Pow(10, shelfGainDB/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	k = math.Tan(math.Pi * passFrequency / sampleRate)
+	a0 = 1 + k/passQ + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/passQ + k*k) / a0,
+	}
+
+	return kWeighting{shelf: shelf, highPass: highPass}
+}
+
+func (k *kWeighting) filter(x float64) float64 {
+	return k.highPass.filter(k.shelf.filter(x))
+}
+
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+// filter runs one sample through the filter in transposed direct form II.
+func (b *biquad) filter(x float64) float64 {
+	y := b.b0*x + b.z1
+	b.z1 = b.b1*x - b.a1*y + b.z2
+	b.z2 = b.b2*x - b.a2*y
+	return y
+}
+
+// IntegratedLoudness returns the integrated loudness of pcm in LUFS.
+func IntegratedLoudness(pcm PCM) float64 {
+	meter := newLoudnessMeter(pcm.SampleRate, pcm.Channels)
+	meter.write(pcm)
+	return meter.integrated()
+}
+
+// measureLoudnessNative streams a WAV or MP3 file through the meter without
+// holding it in memory.
+func measureLoudnessNative(ctx context.Context, inputFilePath string) (float64, error) {
+	stream, err := OpenPCM(inputFilePath)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	// A mono MP3 is decoded to two identical channels, which would meter
+	// 3 dB louder than the single channel ffmpeg measures.
+	mono := stream.sourceChannels == 1 && stream.Channels == 2
+	meter := newLoudnessMeter(stream.SampleRate, stream.Channels)
+	if mono {
+		meter = newLoudnessMeter(stream.SampleRate, 1)
+	}
+	chunk := make([]byte, int(stream.frameSize())*stream.SampleRate)
+	reader := bufio.NewReader(stream.reader)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		n, err := io.ReadFull(reader, chunk)
+		if n > 0 {
+			pcm := PCM{
+				Samples:    bytesToSamples(chunk[:n-n%int(stream.frameSize())]),
+				SampleRate: stream.SampleRate,
+				Channels:   stream.Channels,
+			}
+			if mono {
+				pcm = Downmix(pcm)
+			}
+			meter.write(pcm)
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode %s: %w", inputFilePath, err)
+		}
+	}
+
+	return meter.integrated(), nil
+}
+
+// applyGain scales pcm by gainDB, clipping samples that no longer fit.
+func applyGain(pcm PCM, gainDB float64) PCM {
+	if gainDB == 0 {
+		return pcm
+	}
+
+	factor := math.Pow(10, gainDB/20)
+	scaled := PCM{Samples: make([]int16, len(pcm.Samples)), SampleRate: pcm.SampleRate, Channels: pcm.Channels}
+	for i, sample := range pcm.Samples {
+		scaled.Samples[i] = int16(max(math.MinInt16, min(math.MaxInt16, math.Round(float64(sample)*factor))))
+	}
+
+	return scaled
+}
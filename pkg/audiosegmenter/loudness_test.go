@@ -0,0 +1,336 @@
+package audiosegmenter
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sinePCM returns a 997 Hz sine of the given peak amplitude, relative to
+// full scale, in every channel.
+func sinePCM(seconds float64, sampleRate int, channels int, amplitude float64) PCM {
+	frames := int(seconds * float64(sampleRate))
+	pcm := PCM{Samples: make([]int16, frames*channels), SampleRate: sampleRate, Channels: channels}
+	for i := 0; i < frames; i++ {
+		v := int16(math.Round(amplitude * 32767 * math.Sin(2*math.Pi*997*float64(i)/float64(sampleRate))))
+		for c := 0; c < channels; c++ {
+			pcm.Samples[i*channels+c] = v
+		}
+	}
+	return pcm
+}
+
+type IntegratedLoudnessTest struct {
+	name string
+	pcm  PCM
+	want float64
+}
+
+func TestIntegratedLoudness(t *testing.T) {
+	// BS.1770 calibrates a 0 dBFS sine in one channel to -3.01 LUFS.
+	tests := []IntegratedLoudnessTest{
+		{name: "mono -20 dBFS at 48 kHz", pcm: sinePCM(5, 48000, 1, 0.1), want: -23.01},
+		{name: "mono -20 dBFS at 44.1 kHz", pcm: sinePCM(5, 44100, 1, 0.1), want: -23.01},
+		{name: "stereo -20 dBFS", pcm: sinePCM(5, 48000, 2, 0.1), want: -20.0},
+		{name: "silence", pcm: sinePCM(5, 48000, 2, 0), want: LOUDNESS_ABSOLUTE_GATE},
+	}
+
+	for _, loudnessTest := range tests {
+		t.Run(loudnessTest.name, func(t *testing.T) {
+			if got := IntegratedLoudness(loudnessTest.pcm); math.Abs(got-loudnessTest.want) > 0.05 {
+				t.Errorf("IntegratedLoudness() = %v, want %v", got, loudnessTest.want)
+			}
+		})
+	}
+}
+
+func TestIntegratedLoudness_SurroundWeights(t *testing.T) {
+	// Only the LFE channel of a 5.1 layout, the fourth, carries signal.
+	lfe := sinePCM(5, 48000, 6, 0.5)
+	for i := range lfe.Samples {
+		if i%6 != 3 {
+			lfe.Samples[i] = 0
+		}
+	}
+	if got := IntegratedLoudness(lfe); got != LOUDNESS_ABSOLUTE_GATE {
+		t.Errorf("IntegratedLoudness() of the LFE channel = %v, want it left out", got)
+	}
+
+	// Centre and surround channels of a -20 dBFS sine add up to
+	// -23.01 LUFS for the centre plus 1.5 dB twice for the surrounds.
+	surround := sinePCM(5, 48000, 6, 0.1)
+	for i := range surround.Samples {
+		if c := i % 6; c != 2 && c != 4 && c != 5 {
+			surround.Samples[i] = 0
+		}
+	}
+	want := 10*math.Log10(1+1.41+1.41) - 23.01
+	if got := IntegratedLoudness(surround); math.Abs(got-want) > 0.05 {
+		t.Errorf("IntegratedLoudness() = %v, want %v", got, want)
+	}
+}
+
+func TestIntegratedLoudness_RelativeGate(t *testing.T) {
+	// A passage 20 dB down is gated out; only the blocks straddling the
+	// change in level still lower the result a little.
+	loud := sinePCM(5, 48000, 1, 0.1)
+	quiet := sinePCM(5, 48000, 1, 0.01)
+	pcm := PCM{Samples: append(loud.Samples, quiet.Samples...), SampleRate: 48000, Channels: 1}
+
+	if got := IntegratedLoudness(pcm); math.Abs(got+23.01) > 0.2 {
+		t.Errorf("IntegratedLoudness() = %v, want about -23.01", got)
+	}
+}
+
+type NewLoudnessTest struct {
+	name     string
+	measured float64
+	opts     LoudnessOptions
+	want     Loudness
+}
+
+func TestNewLoudness(t *testing.T) {
+	tests := []NewLoudnessTest{
+		{name: "quiet broadcast", measured: -31.5, opts: LoudnessOptions{}, want: Loudness{MeasuredLUFS: -31.5, TargetLUFS: -23, GainDB: 8.5}},
+		{name: "hot master", measured: -9.25, opts: LoudnessOptions{TargetLUFS: -16}, want: Loudness{MeasuredLUFS: -9.25, TargetLUFS: -16, GainDB: -6.75}},
+		{name: "boost limited", measured: -40, opts: LoudnessOptions{MaxGainDB: 6}, want: Loudness{MeasuredLUFS: -40, TargetLUFS: -23, GainDB: 6}},
+		{name: "silence", measured: LOUDNESS_ABSOLUTE_GATE, opts: LoudnessOptions{}, want: Loudness{MeasuredLUFS: LOUDNESS_ABSOLUTE_GATE, TargetLUFS: -23}},
+	}
+
+	for _, loudnessTest := range tests {
+		t.Run(loudnessTest.name, func(t *testing.T) {
+			if got := NewLoudness(loudnessTest.measured, loudnessTest.opts); got != loudnessTest.want {
+				t.Errorf("NewLoudness() = %+v, want %+v", got, loudnessTest.want)
+			}
+		})
+	}
+}
+
+func TestApplyGain(t *testing.T) {
+	pcm := applyGain(PCM{Samples: []int16{1000, -1000, 20000, -20000}, SampleRate: 8000, Channels: 1}, 6.0206)
+	want := []int16{2000, -2000, math.MaxInt16, math.MinInt16}
+	for i := range want {
+		if pcm.Samples[i] != want[i] {
+			t.Errorf("applyGain() = %v, want %v", pcm.Samples, want)
+			break
+		}
+	}
+}
+
+func TestSegmentAudio_Loudness(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 60)
+	transcoder.loudness[inputFilePath] = -30.5
+	outputDir := t.TempDir()
+	opts := SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 30,
+		Loudness:        &LoudnessOptions{},
+	}
+
+	segments, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	want := Loudness{MeasuredLUFS: -30.5, TargetLUFS: EBU_R128_TARGET_LUFS, GainDB: 7.5}
+	for _, segment := range segments {
+		if segment.Loudness == nil || *segment.Loudness != want {
+			t.Errorf("Segment %d loudness = %+v, want %+v", segment.Index, segment.Loudness, want)
+		}
+	}
+	// The gain is measured once for the source and applied to every cut.
+	for _, cut := range transcoder.cuts {
+		if cut.cut.GainDB != 7.5 {
+			t.Errorf("Cut at %v applied %v dB, want 7.5", cut.start, cut.cut.GainDB)
+		}
+	}
+
+	jsonPath, _ := ManifestPaths(inputFilePath, outputDir)
+	manifest, err := ReadManifest(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.Loudness == nil || *manifest.Loudness != want {
+		t.Errorf("Manifest loudness = %+v, want %+v", manifest.Loudness, want)
+	}
+
+	// Resuming reuses the measurement along with the segments.
+	segments, err = SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if transcoder.measures != 1 || countReused(segments) != len(segments) || *segments[0].Loudness != want {
+		t.Errorf("Resumed run measured %d times and reused %d segments", transcoder.measures, countReused(segments))
+	}
+
+	if _, err := SegmentAudio(context.Background(), inputFilePath, outputDir, SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 30,
+		Loudness:        &LoudnessOptions{TargetLUFS: 3},
+	}); err == nil {
+		t.Errorf("Expected a target above 0 LUFS to be rejected")
+	}
+
+	for _, invalid := range []LoudnessOptions{
+		{TargetLUFS: math.NaN()},
+		{TargetLUFS: math.Inf(-1)},
+		{MaxGainDB: math.NaN()},
+		{MaxGainDB: math.Inf(1)},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestSegmentAudio_NativeLoudness(t *testing.T) {
+	dir := t.TempDir()
+	inputFilePath := filepath.Join(dir, "broadcast.wav")
+	if err := writeWAVFile(inputFilePath, sinePCM(10, 16000, 2, 0.05), 16); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+
+	measured, err := NativeTranscoder{}.MeasureLoudness(context.Background(), inputFilePath)
+	if err != nil || math.Abs(measured+26.02) > 0.1 {
+		t.Fatalf("MeasureLoudness() = %v, %v, want about -26.02", measured, err)
+	}
+
+	segments, err := SegmentAudio(context.Background(), inputFilePath, filepath.Join(dir, "out"), SegmentOptions{
+		CutOptions:      CutOptions{Backend: BACKEND_NATIVE},
+		SegmentDuration: 5,
+		Loudness:        &LoudnessOptions{TargetLUFS: -16},
+	})
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	for _, segment := range segments {
+		if segment.Err != nil {
+			t.Fatalf("Segment %d error = %v", segment.Index, segment.Err)
+		}
+		pcm, err := DecodeFile(segment.OutputPath)
+		if err != nil {
+			t.Fatalf("Failed to decode segment: %v", err)
+		}
+		if got := IntegratedLoudness(pcm); math.Abs(got+16) > 0.1 {
+			t.Errorf("Segment %d loudness = %v LUFS, want -16", segment.Index, got)
+		}
+	}
+}
+
+// bitWriter packs values most significant bit first.
+type bitWriter struct {
+	data []byte
+	bits int
+}
+
+func (w *bitWriter) write(value int, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.bits%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if value>>i&1 != 0 {
+			w.data[len(w.data)-1] |= 0x80 >> (w.bits % 8)
+		}
+		w.bits++
+	}
+}
+
+// writeTestMP3 writes seconds of an MPEG-1 Layer III file at 44.1 kHz and
+// 128 kbps with the same signal in each of its channels. Every granule only
+// codes a run of unit spectral lines in the count1 region, which is enough
+// for a steady tone without an encoder.
+func writeTestMP3(t *testing.T, dir string, name string, channels int, seconds float64) string {
+	t.Helper()
+
+	const (
+		frameSize = 417
+		lines     = 32
+	)
+
+	// Each quadruple of ones is coded as 0000 in table B followed by its
+	// four sign bits.
+	var granule bitWriter
+	for i := 0; i < lines/4; i++ {
+		granule.write(0, 4)
+		granule.write(0b0101, 4)
+	}
+
+	var frame bitWriter
+	frame.write(0xfffb, 16)
+	frame.write(0x90, 8)
+	if channels == 1 {
+		frame.write(0xc0, 8)
+	} else {
+		frame.write(0x00, 8)
+	}
+
+	// Side information: no reservoir, no scalefactors and nothing but the
+	// count1 region in every granule.
+	frame.write(0, 9)
+	frame.write(0, 5-2*(channels-1))
+	frame.write(0, 4*channels)
+	for gr := 0; gr < 2; gr++ {
+		for ch := 0; ch < channels; ch++ {
+			frame.write(granule.bits, 12)
+			frame.write(0, 9)
+			frame.write(190, 8)
+			frame.write(0, 4)
+			frame.write(0, 1)
+			frame.write(0, 15)
+			frame.write(0, 7)
+			frame.write(0, 2)
+			frame.write(1, 1)
+		}
+	}
+	for gr := 0; gr < 2; gr++ {
+		for ch := 0; ch < channels; ch++ {
+			for i := 0; i < granule.bits; i++ {
+				frame.write(int(granule.data[i/8]>>(7-i%8))&1, 1)
+			}
+		}
+	}
+	data := append(frame.data, make([]byte, frameSize-len(frame.data))...)
+
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer file.Close()
+
+	for i := 0; i < int(seconds*44100/1152); i++ {
+		if _, err := file.Write(data); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	return path
+}
+
+func TestMeasureLoudnessNative_MonoMP3(t *testing.T) {
+	dir := t.TempDir()
+	mono := writeTestMP3(t, dir, "mono.mp3", 1, 5)
+	stereo := writeTestMP3(t, dir, "stereo.mp3", 2, 5)
+
+	monoLUFS, err := NativeTranscoder{}.MeasureLoudness(context.Background(), mono)
+	if err != nil {
+		t.Fatalf("MeasureLoudness() of the mono file error = %v", err)
+	}
+	stereoLUFS, err := NativeTranscoder{}.MeasureLoudness(context.Background(), stereo)
+	if err != nil {
+		t.Fatalf("MeasureLoudness() of the stereo file error = %v", err)
+	}
+
+	if monoLUFS <= LOUDNESS_ABSOLUTE_GATE {
+		t.Fatalf("Mono file measured %v LUFS, want a signal above the gate", monoLUFS)
+	}
+	// The same signal in two channels is 3.01 dB louder than in one, as
+	// ffmpeg measures them.
+	if diff := stereoLUFS - monoLUFS; math.Abs(diff-3.01) > 0.1 {
+		t.Errorf("Stereo is %v dB louder than mono (%v and %v LUFS), want 3.01", diff, stereoLUFS, monoLUFS)
+	}
+}
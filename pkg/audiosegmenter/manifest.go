@@ -0,0 +1,236 @@
+package audiosegmenter
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	MANIFEST_JSON_SUFFIX = "_manifest.json"
+	MANIFEST_CSV_SUFFIX  = "_manifest.csv"
+)
+
+var manifestCSVHeader = []string{
+	"source_path",
+	"index",
+	"start",
+	"end",
+	"requested_duration",
+	"actual_duration",
+	"output_path",
+	"size",
+	"sha256",
+	"label",
+	"artist",
+	"composition",
+	"raga",
+	"tala",
+	"tail",
+	"error",
+}
+
+// ManifestEntry describes one segment of a run. OutputPath is relative to
+// the directory holding the manifest so that a segmented collection can be
+// moved as a whole.
+type ManifestEntry struct {
+	Index             int                `json:"index"`
+	SourcePath        string             `json:"source_path"`
+	OutputPath        string             `json:"output_path"`
+	Start             float64            `json:"start"`
+	End               float64            `json:"end"`
+	RequestedDuration float64            `json:"requested_duration"`
+	ActualDuration    float64            `json:"actual_duration"`
+	Size              int64              `json:"size"`
+	SHA256            string             `json:"sha256"`
+	Label             string             `json:"label,omitempty"`
+	Tail              string             `json:"tail,omitempty"`
+	Metadata          *RecordingMetadata `json:"metadata,omitempty"`
+	Error             string             `json:"error,omitempty"`
+}
+
+// Manifest records a segmentation run: the source, the parameters it was
+// run with and every segment it produced.
+type Manifest struct {
+	SourcePath   string `json:"source_path"`
+	SourceSHA256 string `json:"source_sha256,omitempty"`
+	// SourceSize and SourceModTime identify the source file that was hashed,
+	// so that later runs can trust the hash while the file is unchanged.
+	SourceSize    int64           `json:"source_size,omitempty"`
+	SourceModTime *time.Time      `json:"source_mod_time,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Options       SegmentOptions  `json:"options"`
+	Silence       *SilenceOptions `json:"silence,omitempty"`
+	Loudness      *Loudness       `json:"loudness,omitempty"`
+	Segments      []ManifestEntry `json:"segments"`
+}
+
+func ManifestPaths(inputFilePath string, outputDir string) (string, string) {
+	filename := filepath.Base(inputFilePath)
+	filenameWithoutExt := filename[:len(filename)-len(filepath.Ext(filename))]
+
+	return filepath.Join(outputDir, filenameWithoutExt+MANIFEST_JSON_SUFFIX),
+		filepath.Join(outputDir, filenameWithoutExt+MANIFEST_CSV_SUFFIX)
+}
+
+func NewManifest(inputFilePath string, outputDir string, opts SegmentOptions, segments []Segment) Manifest {
+	sourcePath, err := filepath.Abs(inputFilePath)
+	if err != nil {
+		sourcePath = inputFilePath
+	}
+
+	manifest := Manifest{
+		SourcePath: sourcePath,
+		CreatedAt:  time.Now().UTC(),
+		Options:    opts,
+		Segments:   make([]ManifestEntry, 0, len(segments)),
+	}
+
+	for _, segment := range segments {
+		entry := ManifestEntry{
+			Index:             segment.Index,
+			SourcePath:        sourcePath,
+			OutputPath:        segment.OutputPath,
+			Start:             segment.Start,
+			End:               segment.Start + segment.ActualDuration,
+			RequestedDuration: segment.RequestedDuration,
+			ActualDuration:    segment.ActualDuration,
+			Size:              segment.Size,
+			SHA256:            segment.SHA256,
+			Label:             segment.Label,
+			Tail:              segment.Tail,
+			Metadata:          segment.Metadata,
+		}
+
+		if rel, err := filepath.Rel(outputDir, segment.OutputPath); err == nil && segment.OutputPath != "" {
+			entry.OutputPath = filepath.ToSlash(rel)
+		}
+
+		if segment.Err != nil {
+			entry.End = segment.Start + segment.RequestedDuration
+			entry.Error = segment.Err.Error()
+		}
+
+		manifest.Segments = append(manifest.Segments, entry)
+	}
+
+	return manifest
+}
+
+// WriteManifest writes the manifest as both JSON and CSV next to the
+// segments. The run parameters are only part of the JSON file. Both files
+// are replaced atomically.
+func WriteManifest(inputFilePath string, outputDir string, manifest Manifest) error {
+	jsonPath, csvPath := ManifestPaths(inputFilePath, outputDir)
+
+	if err := writeManifestJSON(jsonPath, manifest); err != nil {
+		return err
+	}
+
+	err := writeFileAtomic(csvPath, func(w io.Writer) error {
+		return writeManifestCSV(w, manifest)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write manifest %s: %w", csvPath, err)
+	}
+
+	return nil
+}
+
+func writeManifestJSON(jsonPath string, manifest Manifest) error {
+	if err := os.MkdirAll(filepath.Dir(jsonPath), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", filepath.Dir(jsonPath), err)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	err = writeFileAtomic(jsonPath, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write manifest %s: %w", jsonPath, err)
+	}
+
+	return nil
+}
+
+func writeManifestCSV(w io.Writer, manifest Manifest) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(manifestCSVHeader); err != nil {
+		return err
+	}
+
+	for _, entry := range manifest.Segments {
+		var metadata RecordingMetadata
+		if entry.Metadata != nil {
+			metadata = *entry.Metadata
+		}
+
+		record := []string{
+			entry.SourcePath,
+			strconv.Itoa(entry.Index),
+			formatSeconds(entry.Start),
+			formatSeconds(entry.End),
+			formatSeconds(entry.RequestedDuration),
+			formatSeconds(entry.ActualDuration),
+			entry.OutputPath,
+			strconv.FormatInt(entry.Size, 10),
+			entry.SHA256,
+			entry.Label,
+			metadata.Artist,
+			metadata.Composition,
+			metadata.Raga,
+			metadata.Tala,
+			entry.Tail,
+			entry.Error,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func ReadManifest(manifestPath string) (Manifest, error) {
+	var manifest Manifest
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read manifest %s: %w", manifestPath, err)
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to unmarshal manifest %s: %w", manifestPath, err)
+	}
+
+	return manifest, nil
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
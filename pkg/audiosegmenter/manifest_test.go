@@ -0,0 +1,102 @@
+package audiosegmenter
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteManifest(t *testing.T) {
+	outputDir := t.TempDir()
+	inputFilePath := filepath.Join("..", "..", "sample", "kiravani", "concert.mp3")
+
+	segmentPath := filepath.Join(outputDir, "concert_seg_0", "concert_seg_0.mp3")
+	if err := os.MkdirAll(filepath.Dir(segmentPath), os.ModePerm); err != nil {
+		t.Fatalf("Failed to create segment directory: %v", err)
+	}
+	if err := os.WriteFile(segmentPath, []byte("segment"), 0644); err != nil {
+		t.Fatalf("Failed to write segment: %v", err)
+	}
+	hash, err := hashFile(segmentPath)
+	if err != nil {
+		t.Fatalf("Failed to hash segment: %v", err)
+	}
+	if want := "03e71c6d7dc6bd4e89ceaf32f6488ca0aa69b0de784b706cac5818d680e9d97f"; hash != want {
+		t.Errorf("hashFile() = %q, want %q", hash, want)
+	}
+
+	opts := SegmentOptions{SegmentDuration: 30, HopDuration: 10}
+	segments := []Segment{
+		{
+			Index:             0,
+			SourcePath:        inputFilePath,
+			OutputPath:        segmentPath,
+			Start:             0,
+			RequestedDuration: 30,
+			ActualDuration:    29.98,
+			Size:              7,
+			SHA256:            hash,
+		},
+		{
+			Index:             1,
+			SourcePath:        inputFilePath,
+			Start:             10,
+			RequestedDuration: 30,
+			Err:               errors.New("ffmpeg failed"),
+		},
+	}
+
+	manifest := NewManifest(inputFilePath, outputDir, opts, segments)
+	if err := WriteManifest(inputFilePath, outputDir, manifest); err != nil {
+		t.Fatalf("WriteManifest() error = %v", err)
+	}
+
+	jsonPath, csvPath := ManifestPaths(inputFilePath, outputDir)
+	if filepath.Base(jsonPath) != "concert_manifest.json" || filepath.Base(csvPath) != "concert_manifest.csv" {
+		t.Errorf("Unexpected manifest paths %s and %s", jsonPath, csvPath)
+	}
+
+	read, err := ReadManifest(jsonPath)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+
+	if !filepath.IsAbs(read.SourcePath) {
+		t.Errorf("Manifest source path %s should be absolute", read.SourcePath)
+	}
+	if !reflect.DeepEqual(read.Options, opts) {
+		t.Errorf("Manifest options = %+v, want %+v", read.Options, opts)
+	}
+	if len(read.Segments) != 2 {
+		t.Fatalf("Manifest has %d segments, want 2", len(read.Segments))
+	}
+	if read.Segments[0].OutputPath != "concert_seg_0/concert_seg_0.mp3" {
+		t.Errorf("Output path %s should be relative to the output directory", read.Segments[0].OutputPath)
+	}
+	if read.Segments[0].End != 29.98 || read.Segments[0].SHA256 != hash {
+		t.Errorf("Unexpected first entry %+v", read.Segments[0])
+	}
+	if read.Segments[1].Error == "" || read.Segments[1].End != 40 {
+		t.Errorf("Failed segment should keep its requested range and error, got %+v", read.Segments[1])
+	}
+
+	csvFile, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatalf("Failed to open CSV manifest: %v", err)
+	}
+	defer csvFile.Close()
+
+	records, err := csv.NewReader(csvFile).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV manifest: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("CSV manifest has %d rows, want header and 2 segments", len(records))
+	}
+	if records[1][1] != "0" || records[1][3] != "29.98" || records[1][8] != hash {
+		t.Errorf("Unexpected CSV row %v", records[1])
+	}
+}
@@ -0,0 +1,109 @@
+package audiosegmenter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Tag names are matched case-insensitively in order of preference, so that
+// both "RAGA" Vorbis comments and "raga" ID3 user frames are picked up.
+var (
+	compositionTags = []string{"composition", "work", "title"}
+	ragaTags        = []string{"raga", "ragam", "raag"}
+	talaTags        = []string{"tala", "talam", "taala", "taal"}
+)
+
+// RecordingMetadata is the tag metadata of a recording that labels every
+// segment cut from it. Tags holds all tags with lowercased names.
+type RecordingMetadata struct {
+	Title       string            `json:"title,omitempty"`
+	Artist      string            `json:"artist,omitempty"`
+	Album       string            `json:"album,omitempty"`
+	Composer    string            `json:"composer,omitempty"`
+	Composition string            `json:"composition,omitempty"`
+	Raga        string            `json:"raga,omitempty"`
+	Tala        string            `json:"tala,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// NewRecordingMetadata picks the known fields out of tags. When no dedicated
+// tag names the composition, the title is used, since that is how most
+// concert recordings are tagged.
+func NewRecordingMetadata(tags map[string]string) RecordingMetadata {
+	metadata := RecordingMetadata{Tags: map[string]string{}}
+
+	for key, value := range tags {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			metadata.Tags[strings.ToLower(key)] = value
+		}
+	}
+
+	lookup := func(keys ...string) string {
+		for _, key := range keys {
+			if value, ok := metadata.Tags[key]; ok {
+				return value
+			}
+		}
+		return ""
+	}
+
+	metadata.Title = lookup("title")
+	metadata.Artist = lookup("artist", "album_artist", "performer")
+	metadata.Album = lookup("album")
+	metadata.Composer = lookup("composer")
+	metadata.Composition = lookup(compositionTags...)
+	metadata.Raga = lookup(ragaTags...)
+	metadata.Tala = lookup(talaTags...)
+
+	if len(metadata.Tags) == 0 {
+		metadata.Tags = nil
+	}
+
+	return metadata
+}
+
+// IsEmpty reports whether the recording carried no tags at all.
+func (m RecordingMetadata) IsEmpty() bool {
+	return len(m.Tags) == 0
+}
+
+// ParseRecordingMetadata reads the tags from ffprobe JSON output. Container
+// tags such as ID3 are on the format, while Ogg and Opus keep their Vorbis
+// comments on the audio stream; stream tags win when both are present.
+func ParseRecordingMetadata(probeOutput string) (RecordingMetadata, error) {
+	var probe FFProbeOutput
+	if err := json.Unmarshal([]byte(probeOutput), &probe); err != nil {
+		return RecordingMetadata{}, fmt.Errorf("failed to unmarshal probe output: %w", err)
+	}
+
+	tags := map[string]string{}
+	for key, value := range probe.Format.Tags {
+		tags[strings.ToLower(key)] = value
+	}
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+		for key, value := range stream.Tags {
+			tags[strings.ToLower(key)] = value
+		}
+		break
+	}
+
+	return NewRecordingMetadata(tags), nil
+}
+
+// ReadMetadata returns the tag metadata of inputFilePath using the backend
+// NewTranscoder selects by default.
+func ReadMetadata(ctx context.Context, inputFilePath string) (RecordingMetadata, error) {
+	transcoder, err := NewTranscoder("")
+	if err != nil {
+		return RecordingMetadata{}, err
+	}
+
+	return transcoder.ProbeMetadata(ctx, inputFilePath)
+}
@@ -0,0 +1,224 @@
+package audiosegmenter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+type ParseRecordingMetadataTest struct {
+	name        string
+	probeOutput string
+	want        RecordingMetadata
+	wantErr     bool
+}
+
+func TestParseRecordingMetadata(t *testing.T) {
+	tests := []ParseRecordingMetadataTest{
+		{
+			name: "id3 tags on the format",
+			probeOutput: `{"format": {"tags": {"title": "kaligiyuNTEgadA", "artist": "M Balamuralikrishna",
+				"composer": "Tyagaraja", "RAGA": "kIravANi", "tala": "Adi"}}}`,
+			want: RecordingMetadata{
+				Title:       "kaligiyuNTEgadA",
+				Artist:      "M Balamuralikrishna",
+				Composer:    "Tyagaraja",
+				Composition: "kaligiyuNTEgadA",
+				Raga:        "kIravANi",
+				Tala:        "Adi",
+				Tags: map[string]string{
+					"title": "kaligiyuNTEgadA", "artist": "M Balamuralikrishna",
+					"composer": "Tyagaraja", "raga": "kIravANi", "tala": "Adi",
+				},
+			},
+		},
+		{
+			name: "vorbis comments on the stream",
+			probeOutput: `{"streams": [{"codec_type": "audio", "tags": {"ARTIST": "T M Krishna", "WORK": "nagumOmu",
+				"TITLE": "nagumOmu (live)", "RAGAM": "AbhEri"}}], "format": {"tags": {"ARTIST": "various"}}}`,
+			want: RecordingMetadata{
+				Title:       "nagumOmu (live)",
+				Artist:      "T M Krishna",
+				Composition: "nagumOmu",
+				Raga:        "AbhEri",
+				Tags: map[string]string{
+					"artist": "T M Krishna", "work": "nagumOmu", "title": "nagumOmu (live)", "ragam": "AbhEri",
+				},
+			},
+		},
+		{
+			name:        "no tags",
+			probeOutput: `{"format": {"duration": "1"}}`,
+			want:        RecordingMetadata{},
+		},
+		{
+			name:        "invalid JSON",
+			probeOutput: `{"format": }`,
+			wantErr:     true,
+		},
+	}
+
+	for _, parseTest := range tests {
+		t.Run(parseTest.name, func(t *testing.T) {
+			metadata, err := ParseRecordingMetadata(parseTest.probeOutput)
+			if (err != nil) != parseTest.wantErr {
+				t.Errorf("ParseRecordingMetadata() error = %v, wantErr %v", err, parseTest.wantErr)
+				return
+			}
+			if parseTest.wantErr {
+				return
+			}
+
+			if !reflect.DeepEqual(metadata, parseTest.want) {
+				t.Errorf("ParseRecordingMetadata() = %+v, want %+v", metadata, parseTest.want)
+			}
+		})
+	}
+}
+
+func id3Frame(version byte, id string, body []byte) []byte {
+	frame := []byte(id)
+	size := make([]byte, 4)
+	if version == 4 {
+		size = []byte{byte(len(body) >> 21 & 0x7f), byte(len(body) >> 14 & 0x7f), byte(len(body) >> 7 & 0x7f), byte(len(body) & 0x7f)}
+	} else {
+		binary.BigEndian.PutUint32(size, uint32(len(body)))
+	}
+	frame = append(frame, size...)
+	frame = append(frame, 0, 0)
+	return append(frame, body...)
+}
+
+func id3Tag(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	// Padding after the last frame.
+	body = append(body, make([]byte, 16)...)
+
+	tag := []byte{'I', 'D', '3', version, 0, 0}
+	tag = append(tag, byte(len(body)>>21&0x7f), byte(len(body)>>14&0x7f), byte(len(body)>>7&0x7f), byte(len(body)&0x7f))
+	return append(tag, body...)
+}
+
+func utf16Text(s string) []byte {
+	text := []byte{0xff, 0xfe}
+	for _, unit := range utf16.Encode([]rune(s)) {
+		text = binary.LittleEndian.AppendUint16(text, unit)
+	}
+	return text
+}
+
+func TestReadID3Tags(t *testing.T) {
+	txxx := append([]byte{1}, utf16Text("RAGA")...)
+	txxx = append(txxx, 0, 0)
+	txxx = append(txxx, utf16Text("kIravANi")...)
+
+	v23 := id3Tag(3,
+		id3Frame(3, "TIT2", append([]byte{0}, "kaligiyunTe\x00"...)),
+		id3Frame(3, "TPE1", append([]byte{1}, utf16Text("Bālamuraḷīkr̥ṣṇa")...)),
+		id3Frame(3, "TXXX", txxx),
+		id3Frame(3, "APIC", []byte{0, 1, 2, 3}),
+	)
+
+	tags, err := readID3Tags(bytes.NewReader(v23))
+	if err != nil {
+		t.Fatalf("readID3Tags() error = %v", err)
+	}
+	want := map[string]string{"title": "kaligiyunTe", "artist": "Bālamuraḷīkr̥ṣṇa", "raga": "kIravANi"}
+	if len(tags) != len(want) {
+		t.Errorf("readID3Tags() = %v, want %v", tags, want)
+	}
+	for key, value := range want {
+		if tags[key] != value {
+			t.Errorf("Tag %s = %q, want %q", key, tags[key], value)
+		}
+	}
+
+	v24 := id3Tag(4, id3Frame(4, "TCOM", append([]byte{3}, "Tyāgarāja\x00Tyagaraja"...)))
+	tags, err = readID3Tags(bytes.NewReader(v24))
+	if err != nil {
+		t.Fatalf("readID3Tags() error = %v", err)
+	}
+	if tags["composer"] != "Tyāgarāja" {
+		t.Errorf("readID3Tags() composer = %q, want the first value", tags["composer"])
+	}
+
+	tags, err = readID3Tags(bytes.NewReader([]byte("RIFF")))
+	if err != nil || len(tags) != 0 {
+		t.Errorf("readID3Tags() on an untagged file = %v, %v", tags, err)
+	}
+}
+
+func TestNativeTranscoderProbeMetadata(t *testing.T) {
+	metadata, err := NativeTranscoder{}.ProbeMetadata(context.Background(), filepath.Join(INPUT_DIR, NATIVE_SAMPLE_FILE_NAME))
+	if err != nil {
+		t.Fatalf("ProbeMetadata() error = %v", err)
+	}
+
+	if metadata.Artist != "MYSORE_M_NAGARAJ-M_MANJUNATH_VIOLIN_DUET" || metadata.Composition != metadata.Title || metadata.Title == "" {
+		t.Errorf("ProbeMetadata() = %+v", metadata)
+	}
+}
+
+func TestSegmentAudio_InheritsMetadata(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 25)
+	transcoder.metadata[inputFilePath] = NewRecordingMetadata(map[string]string{
+		"artist": "M S Subbulakshmi",
+		"title":  "bhAvayAmi",
+		"raga":   "rAgamAlikA",
+		"tala":   "rUpakam",
+	})
+	outputDir := t.TempDir()
+
+	segments, err := SegmentAudio(context.Background(), inputFilePath, outputDir, SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 10,
+	})
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	for _, segment := range segments {
+		if segment.Metadata == nil || segment.Metadata.Raga != "rAgamAlikA" {
+			t.Errorf("Segment %d metadata = %+v", segment.Index, segment.Metadata)
+		}
+	}
+
+	jsonPath, csvPath := ManifestPaths(inputFilePath, outputDir)
+	manifest, err := ReadManifest(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	for _, entry := range manifest.Segments {
+		if entry.Metadata == nil || entry.Metadata.Tala != "rUpakam" {
+			t.Errorf("Manifest entry %d metadata = %+v", entry.Index, entry.Metadata)
+		}
+	}
+
+	csvFile, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatalf("Failed to open CSV manifest: %v", err)
+	}
+	defer csvFile.Close()
+
+	records, err := csv.NewReader(csvFile).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV manifest: %v", err)
+	}
+	header := records[0]
+	for _, record := range records[1:] {
+		row := map[string]string{}
+		for i, column := range header {
+			row[column] = record[i]
+		}
+		if row["artist"] != "M S Subbulakshmi" || row["composition"] != "bhAvayAmi" || row["raga"] != "rAgamAlikA" || row["tala"] != "rUpakam" {
+			t.Errorf("CSV manifest row = %v", row)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package audiosegmenter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// LAYOUT_NESTED writes every segment into a directory of its own, named
+	// like the segment without its extension.
+	LAYOUT_NESTED = "nested"
+	// LAYOUT_FLAT writes the segments straight into the output directory.
+	LAYOUT_FLAT = "flat"
+
+	// DEFAULT_NAME_TEMPLATE names segments <name>_seg_<index>[_<label>].<ext>.
+	DEFAULT_NAME_TEMPLATE = "{name}_seg_{index}{_label}.{ext}"
+)
+
+// nameTemplateFields are the fields a name template can use.
+var nameTemplateFields = map[string]bool{
+	"name":  true,
+	"index": true,
+	"start": true,
+	"end":   true,
+	"label": true,
+	"ext":   true,
+}
+
+// namePart is a literal or a field of a parsed name template.
+type namePart struct {
+	literal string
+	field   string
+	// width zero-pads a numeric field.
+	width int
+	// underscore precedes the field with an underscore unless it is empty.
+	underscore bool
+}
+
+// parseNameTemplate parses a template of literals and {field} placeholders
+// as described by SegmentPath.
+func parseNameTemplate(template string) ([]namePart, error) {
+	var parts []namePart
+	hasIndex, hasName := false, false
+
+	for rest := template; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if close := strings.IndexByte(rest, '}'); close >= 0 && (open < 0 || close < open) {
+			return nil, fmt.Errorf("unmatched } in name template %q", template)
+		}
+		if open < 0 {
+			parts = append(parts, namePart{literal: rest})
+			break
+		}
+		if open > 0 {
+			parts = append(parts, namePart{literal: rest[:open]})
+		}
+
+		close := strings.IndexByte(rest[open:], '}')
+		if close < 0 {
+			return nil, fmt.Errorf("unclosed { in name template %q", template)
+		}
+		placeholder := rest[open+1 : open+close]
+		rest = rest[open+close+1:]
+
+		part := namePart{field: placeholder}
+		if strings.HasPrefix(part.field, "_") {
+			part.field, part.underscore = part.field[1:], true
+		}
+		if field, width, ok := strings.Cut(part.field, ":"); ok {
+			n, err := strconv.Atoi(width)
+			if err != nil || n <= 0 || field != "index" {
+				return nil, fmt.Errorf("invalid padding in {%s} of name template %q", placeholder, template)
+			}
+			part.field, part.width = field, n
+		}
+		if !nameTemplateFields[part.field] {
+			return nil, fmt.Errorf("unknown field {%s} in name template %q", placeholder, template)
+		}
+
+		hasIndex = hasIndex || part.field == "index"
+		hasName = hasName || part.field == "name"
+		parts = append(parts, part)
+	}
+
+	if !hasIndex || !hasName {
+		return nil, fmt.Errorf("name template %q must contain {name} and {index}", template)
+	}
+
+	return parts, nil
+}
+
+// validateNaming checks the name template and layout of cut options.
+func validateNaming(template string, layout string) error {
+	switch layout {
+	case "", LAYOUT_NESTED, LAYOUT_FLAT:
+	default:
+		return fmt.Errorf("unknown layout %q", layout)
+	}
+
+	if template == "" {
+		return nil
+	}
+	_, err := parseNameTemplate(template)
+	return err
+}
+
+// SegmentPath returns the path of segment relative to the output directory,
+// as named by cut.NameTemplate and laid out by cut.Layout. The template is
+// made of literals and these fields:
+//
+//	{name}   the source file name without its extension
+//	{index}  the segment index; {index:4} zero-pads it to four digits
+//	{start}  the start of the segment in seconds, to the millisecond
+//	{end}    the requested end of the segment in seconds
+//	{label}  the annotation the segment was cut for, made safe for file names
+//	{ext}    the extension of the output format, without the dot
+//
+// A field written with a leading underscore, as in {_label}, is preceded by
+// an underscore unless it is empty. Templates may contain slashes to sort
+// segments into subdirectories but must include {name} and {index}, so that
+// neither the segments of one recording nor those of recordings sharing an
+// output directory overwrite each other.
+func SegmentPath(segment Segment, cut CutOptions) (string, error) {
+	template := cut.NameTemplate
+	if template == "" {
+		template = DEFAULT_NAME_TEMPLATE
+	}
+
+	parts, err := parseNameTemplate(template)
+	if err != nil {
+		return "", err
+	}
+
+	filename := filepath.Base(segment.SourcePath)
+	values := map[string]string{
+		"name":  filename[:len(filename)-len(filepath.Ext(filename))],
+		"start": strconv.FormatFloat(segment.Start, 'f', 3, 64),
+		"end":   strconv.FormatFloat(segment.Start+segment.RequestedDuration, 'f', 3, 64),
+		"label": labelSuffix(cut.Label),
+		"ext":   cut.Output.Extension(segment.SourcePath),
+	}
+
+	var name strings.Builder
+	for _, part := range parts {
+		if part.field == "" {
+			name.WriteString(part.literal)
+			continue
+		}
+
+		value := values[part.field]
+		if part.field == "index" {
+			value = fmt.Sprintf("%0*d", part.width, segment.Index)
+		}
+
+		if part.underscore && value != "" {
+			name.WriteByte('_')
+		}
+		name.WriteString(value)
+	}
+
+	path := filepath.FromSlash(name.String())
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("name template %q must produce a relative path inside the output directory, got %q", template, name.String())
+	}
+
+	if cut.Layout == LAYOUT_FLAT {
+		return path, nil
+	}
+
+	base := filepath.Base(path)
+	return filepath.Join(filepath.Dir(path), strings.TrimSuffix(base, filepath.Ext(base)), base), nil
+}
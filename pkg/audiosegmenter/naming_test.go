@@ -0,0 +1,146 @@
+package audiosegmenter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type SegmentPathTest struct {
+	name    string
+	segment Segment
+	cut     CutOptions
+	want    string
+	wantErr bool
+}
+
+func TestSegmentPath(t *testing.T) {
+	segment := Segment{Index: 7, SourcePath: "/music/todi.mp3", Start: 210, RequestedDuration: 30}
+
+	tests := []SegmentPathTest{
+		{
+			name:    "default",
+			segment: segment,
+			want:    "todi_seg_7/todi_seg_7.mp3",
+		},
+		{
+			name:    "default with label",
+			segment: segment,
+			cut:     CutOptions{Label: "kriti: nagumOmu"},
+			want:    "todi_seg_7_kriti_nagumOmu/todi_seg_7_kriti_nagumOmu.mp3",
+		},
+		{
+			name:    "flat zero-padded",
+			segment: segment,
+			cut:     CutOptions{NameTemplate: "{name}_{index:4}.{ext}", Layout: LAYOUT_FLAT, Output: OutputSpec{Format: FORMAT_WAV}},
+			want:    "todi_0007.wav",
+		},
+		{
+			name:    "times",
+			segment: segment,
+			cut:     CutOptions{NameTemplate: "{name}-{index}-{start}-{end}.{ext}", Layout: LAYOUT_FLAT},
+			want:    "todi-7-210.000-240.000.mp3",
+		},
+		{
+			name:    "label directories",
+			segment: segment,
+			cut:     CutOptions{NameTemplate: "{label}/{name}{_index}.{ext}", Layout: LAYOUT_FLAT, Label: "alapana"},
+			want:    "alapana/todi_7.mp3",
+		},
+		{
+			name:    "nested under label directories",
+			segment: segment,
+			cut:     CutOptions{NameTemplate: "{label}/{name}_{index}.{ext}", Label: "alapana"},
+			want:    "alapana/todi_7/todi_7.mp3",
+		},
+		{
+			name:    "escapes the output directory",
+			segment: segment,
+			cut:     CutOptions{NameTemplate: "../{name}_{index}.{ext}"},
+			wantErr: true,
+		},
+	}
+
+	for _, pathTest := range tests {
+		t.Run(pathTest.name, func(t *testing.T) {
+			got, err := SegmentPath(pathTest.segment, pathTest.cut)
+			if (err != nil) != pathTest.wantErr {
+				t.Errorf("SegmentPath() error = %v, wantErr %v", err, pathTest.wantErr)
+				return
+			}
+			if !pathTest.wantErr && got != filepath.FromSlash(pathTest.want) {
+				t.Errorf("SegmentPath() = %s, want %s", got, pathTest.want)
+			}
+		})
+	}
+}
+
+func TestValidateNaming(t *testing.T) {
+	for _, template := range []string{
+		"{name}.{ext}",
+		"{name}_{idx}.{ext}",
+		"{name}_{index.{ext}",
+		"{name}}_{index}.{ext}",
+		"{name:3}_{index}.{ext}",
+		"{name}_{index:0}.{ext}",
+		"{index}.{ext}",
+		"{label}/{index}.{ext}",
+	} {
+		if err := validateNaming(template, ""); err == nil {
+			t.Errorf("Expected name template %q to be rejected", template)
+		}
+	}
+
+	if err := validateNaming("", "tree"); err == nil {
+		t.Errorf("Expected an unknown layout to be rejected")
+	}
+}
+
+func TestSegmentAudio_FlatLayout(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 75)
+	outputDir := t.TempDir()
+	opts := SegmentOptions{
+		CutOptions: CutOptions{
+			Transcoder:   transcoder,
+			NameTemplate: "{name}_{index:3}.{ext}",
+			Layout:       LAYOUT_FLAT,
+		},
+		SegmentDuration: 30,
+	}
+
+	segments, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		t.Fatalf("Failed to read output directory: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{"concert_000.mp3", "concert_001.mp3", "concert_002.mp3", "concert_manifest.csv", "concert_manifest.json"}
+	if len(names) != len(want) {
+		t.Fatalf("Output directory holds %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("Output directory holds %v, want %v", names, want)
+			break
+		}
+	}
+
+	// Changing the layout cuts the segments again under their new names.
+	opts.Layout = LAYOUT_NESTED
+	nested, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if countReused(nested) != 0 || nested[0].OutputPath == segments[0].OutputPath {
+		t.Errorf("Expected the nested run to cut again, got %+v", nested[0])
+	}
+}
@@ -0,0 +1,109 @@
+package audiosegmenter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	ffmpeg_go "github.com/u2takey/ffmpeg-go"
+)
+
+const (
+	FORMAT_MP3  = "mp3"
+	FORMAT_WAV  = "wav"
+	FORMAT_FLAC = "flac"
+)
+
+// OutputSpec describes the files written for each segment. The zero value
+// keeps the container, codec and sample layout of the source.
+type OutputSpec struct {
+	// Format is the container and codec to write: FORMAT_MP3, FORMAT_WAV or
+	// FORMAT_FLAC. Empty keeps the source format.
+	Format string `json:"format,omitempty"`
+	// SampleRate in Hz. Zero keeps the source rate.
+	SampleRate int `json:"sample_rate,omitempty"`
+	// Channels is the number of output channels. Zero keeps the source layout.
+	Channels int `json:"channels,omitempty"`
+	// BitDepth is the sample size of WAV and FLAC output. Zero uses 16 bits.
+	BitDepth int `json:"bit_depth,omitempty"`
+}
+
+func (spec OutputSpec) Validate() error {
+	switch spec.Format {
+	case "", FORMAT_MP3, FORMAT_WAV, FORMAT_FLAC:
+	default:
+		return fmt.Errorf("unsupported output format %q", spec.Format)
+	}
+
+	if spec.SampleRate < 0 {
+		return fmt.Errorf("sample rate must not be negative, got %d", spec.SampleRate)
+	}
+	if spec.Channels < 0 {
+		return fmt.Errorf("channel count must not be negative, got %d", spec.Channels)
+	}
+
+	if spec.BitDepth != 0 {
+		if spec.Format != FORMAT_WAV && spec.Format != FORMAT_FLAC {
+			return fmt.Errorf("bit depth can only be set for %s and %s output", FORMAT_WAV, FORMAT_FLAC)
+		}
+		if spec.BitDepth != 16 && spec.BitDepth != 24 && (spec.BitDepth != 32 || spec.Format == FORMAT_FLAC) {
+			return fmt.Errorf("unsupported bit depth %d for %s output", spec.BitDepth, spec.Format)
+		}
+	}
+
+	return nil
+}
+
+// Extension returns the file extension, without the leading dot, of segments
+// cut from inputFilePath.
+func (spec OutputSpec) Extension(inputFilePath string) string {
+	if spec.Format != "" {
+		return spec.Format
+	}
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(inputFilePath), "."))
+}
+
+// IsPassthrough reports whether the spec leaves the source stream untouched,
+// which is what allows segments to be stream copied.
+func (spec OutputSpec) IsPassthrough(inputFilePath string) bool {
+	return spec.SampleRate == 0 &&
+		spec.Channels == 0 &&
+		spec.BitDepth == 0 &&
+		spec.Extension(inputFilePath) == strings.ToLower(strings.TrimPrefix(filepath.Ext(inputFilePath), "."))
+}
+
+// encodeArgs returns the ffmpeg output arguments that select the codec and
+// sample layout of the spec.
+func (spec OutputSpec) encodeArgs() ffmpeg_go.KwArgs {
+	args := ffmpeg_go.KwArgs{}
+
+	bitDepth := spec.BitDepth
+	if bitDepth == 0 {
+		bitDepth = 16
+	}
+
+	switch spec.Format {
+	case FORMAT_MP3:
+		args["c:a"] = "libmp3lame"
+	case FORMAT_WAV:
+		args["c:a"] = fmt.Sprintf("pcm_s%dle", bitDepth)
+	case FORMAT_FLAC:
+		args["c:a"] = "flac"
+		if bitDepth == 16 {
+			args["sample_fmt"] = "s16"
+		} else {
+			args["sample_fmt"] = "s32"
+			args["bits_per_raw_sample"] = strconv.Itoa(bitDepth)
+		}
+	}
+
+	if spec.SampleRate > 0 {
+		args["ar"] = strconv.Itoa(spec.SampleRate)
+	}
+	if spec.Channels > 0 {
+		args["ac"] = strconv.Itoa(spec.Channels)
+	}
+
+	return args
+}
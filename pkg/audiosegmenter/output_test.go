@@ -0,0 +1,103 @@
+package audiosegmenter
+
+import "testing"
+
+type OutputSpecTest struct {
+	name            string
+	spec            OutputSpec
+	wantErr         bool
+	wantExtension   string
+	wantPassthrough bool
+}
+
+func TestOutputSpec(t *testing.T) {
+	const inputFilePath = "concert.MP3"
+
+	tests := []OutputSpecTest{
+		{
+			name:            "zero value keeps source",
+			spec:            OutputSpec{},
+			wantExtension:   "mp3",
+			wantPassthrough: true,
+		},
+		{
+			name:            "same format is passthrough",
+			spec:            OutputSpec{Format: FORMAT_MP3},
+			wantExtension:   "mp3",
+			wantPassthrough: true,
+		},
+		{
+			name:            "resampling is not passthrough",
+			spec:            OutputSpec{SampleRate: 44100},
+			wantExtension:   "mp3",
+			wantPassthrough: false,
+		},
+		{
+			name:            "mono wav",
+			spec:            OutputSpec{Format: FORMAT_WAV, SampleRate: 44100, Channels: 1},
+			wantExtension:   "wav",
+			wantPassthrough: false,
+		},
+		{
+			name:            "24-bit flac",
+			spec:            OutputSpec{Format: FORMAT_FLAC, BitDepth: 24},
+			wantExtension:   "flac",
+			wantPassthrough: false,
+		},
+		{
+			name:    "unknown format",
+			spec:    OutputSpec{Format: "ogg"},
+			wantErr: true,
+		},
+		{
+			name:    "bit depth on mp3",
+			spec:    OutputSpec{Format: FORMAT_MP3, BitDepth: 16},
+			wantErr: true,
+		},
+		{
+			name:    "32-bit flac",
+			spec:    OutputSpec{Format: FORMAT_FLAC, BitDepth: 32},
+			wantErr: true,
+		},
+		{
+			name:    "negative channels",
+			spec:    OutputSpec{Channels: -1},
+			wantErr: true,
+		},
+	}
+
+	for _, specTest := range tests {
+		t.Run(specTest.name, func(t *testing.T) {
+			err := specTest.spec.Validate()
+			if (err != nil) != specTest.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, specTest.wantErr)
+			}
+			if specTest.wantErr {
+				return
+			}
+			if ext := specTest.spec.Extension(inputFilePath); ext != specTest.wantExtension {
+				t.Errorf("Extension() = %q, want %q", ext, specTest.wantExtension)
+			}
+			if passthrough := specTest.spec.IsPassthrough(inputFilePath); passthrough != specTest.wantPassthrough {
+				t.Errorf("IsPassthrough() = %v, want %v", passthrough, specTest.wantPassthrough)
+			}
+		})
+	}
+}
+
+func TestOutputSpecEncodeArgs(t *testing.T) {
+	args := OutputSpec{Format: FORMAT_FLAC, BitDepth: 24, SampleRate: 44100, Channels: 1}.encodeArgs()
+
+	want := map[string]string{
+		"c:a":                 "flac",
+		"sample_fmt":          "s32",
+		"bits_per_raw_sample": "24",
+		"ar":                  "44100",
+		"ac":                  "1",
+	}
+	for key, value := range want {
+		if args[key] != value {
+			t.Errorf("encodeArgs()[%q] = %v, want %v", key, args[key], value)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package audiosegmenter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// AudioInfo describes the first audio stream of a file.
+type AudioInfo struct {
+	FormatName    string  `json:"format_name"`
+	Codec         string  `json:"codec"`
+	StreamIndex   int     `json:"stream_index"`
+	SampleRate    int     `json:"sample_rate"`
+	Channels      int     `json:"channels"`
+	ChannelLayout string  `json:"channel_layout,omitempty"`
+	BitDepth      int     `json:"bit_depth,omitempty"`
+	BitRate       int64   `json:"bit_rate,omitempty"`
+	StartTime     float64 `json:"start_time"`
+	Duration      float64 `json:"duration"`
+}
+
+// NeedsResample reports whether the stream has to be resampled to sampleRate.
+func (info AudioInfo) NeedsResample(sampleRate int) bool {
+	return sampleRate > 0 && info.SampleRate != sampleRate
+}
+
+// NeedsDownmix reports whether the stream has more than channels channels.
+func (info AudioInfo) NeedsDownmix(channels int) bool {
+	return channels > 0 && info.Channels > channels
+}
+
+// ProbeAudio returns the audio stream information of inputFilePath using the
+// backend NewTranscoder selects by default.
+func ProbeAudio(ctx context.Context, inputFilePath string) (AudioInfo, error) {
+	transcoder, err := NewTranscoder("")
+	if err != nil {
+		return AudioInfo{}, err
+	}
+
+	return transcoder.ProbeAudio(ctx, inputFilePath)
+}
+
+// ParseAudioInfo reads the first audio stream from ffprobe JSON output.
+// Values the stream leaves out, such as the bit rate of some lossless codecs,
+// fall back to the container's.
+func ParseAudioInfo(probeOutput string) (AudioInfo, error) {
+	var probe FFProbeOutput
+	if err := json.Unmarshal([]byte(probeOutput), &probe); err != nil {
+		return AudioInfo{}, fmt.Errorf("failed to unmarshal probe output: %w", err)
+	}
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+
+		info := AudioInfo{
+			FormatName:    probe.Format.FormatName,
+			Codec:         stream.CodecName,
+			StreamIndex:   stream.Index,
+			Channels:      stream.Channels,
+			ChannelLayout: stream.ChannelLayout,
+			BitDepth:      stream.BitsPerSample,
+		}
+
+		var err error
+		if info.SampleRate, err = strconv.Atoi(stream.SampleRate); err != nil {
+			return info, fmt.Errorf("failed to parse sample rate: %w", err)
+		}
+		if info.BitDepth == 0 {
+			if info.BitDepth, err = parseProbeInt(stream.BitsPerRawSample); err != nil {
+				return info, fmt.Errorf("failed to parse bits per raw sample: %w", err)
+			}
+		}
+		if info.BitRate, err = parseProbeInt64(firstProbeValue(stream.BitRate, probe.Format.BitRate)); err != nil {
+			return info, fmt.Errorf("failed to parse bit rate: %w", err)
+		}
+		if info.StartTime, err = parseProbeFloat(firstProbeValue(stream.StartTime, probe.Format.StartTime)); err != nil {
+			return info, fmt.Errorf("failed to parse start time: %w", err)
+		}
+		if info.Duration, err = parseProbeFloat(firstProbeValue(stream.Duration, probe.Format.Duration)); err != nil {
+			return info, fmt.Errorf("failed to parse duration: %w", err)
+		}
+
+		return info, nil
+	}
+
+	return AudioInfo{}, errors.New("no audio stream found")
+}
+
+// ffprobe reports values it does not know as missing or "N/A".
+func firstProbeValue(values ...string) string {
+	for _, value := range values {
+		if value != "" && value != "N/A" {
+			return value
+		}
+	}
+	return ""
+}
+
+func parseProbeFloat(value string) (float64, error) {
+	if firstProbeValue(value) == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func parseProbeInt64(value string) (int64, error) {
+	if firstProbeValue(value) == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func parseProbeInt(value string) (int, error) {
+	n, err := parseProbeInt64(value)
+	return int(n), err
+}
+
+// audioInfo describes the stream as the pure-Go decoders see it. MP3 is
+// always decoded to stereo, so Channels is 2 even for mono MP3 files, and its
+// bit rate is averaged over the file like ffprobe does for the container.
+func (s *PCMStream) audioInfo(inputFilePath string) (AudioInfo, error) {
+	info := AudioInfo{
+		FormatName: s.format,
+		Codec:      s.codec,
+		SampleRate: s.SampleRate,
+		Channels:   s.sourceChannels,
+		BitDepth:   s.bitDepth,
+		Duration:   s.Duration(),
+	}
+
+	if s.bitDepth > 0 {
+		info.BitRate = int64(s.SampleRate * s.sourceChannels * s.bitDepth)
+		return info, nil
+	}
+
+	stat, err := os.Stat(inputFilePath)
+	if err != nil {
+		return info, err
+	}
+	if info.Duration > 0 {
+		info.BitRate = int64(float64(stat.Size()*8) / info.Duration)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,139 @@
+package audiosegmenter
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+const FLAC_PROBE_OUTPUT = `{
+	"streams": [
+		{"index": 0, "codec_name": "mjpeg", "codec_type": "video"},
+		{
+			"index": 1,
+			"codec_name": "flac",
+			"codec_type": "audio",
+			"sample_fmt": "s32",
+			"sample_rate": "96000",
+			"channels": 2,
+			"channel_layout": "stereo",
+			"bits_per_sample": 0,
+			"bits_per_raw_sample": "24",
+			"start_time": "0.000000",
+			"duration": "312.500000"
+		}
+	],
+	"format": {
+		"format_name": "flac",
+		"start_time": "0.000000",
+		"duration": "312.500000",
+		"bit_rate": "3072000",
+		"nb_streams": 2
+	}
+}`
+
+type ParseAudioInfoTest struct {
+	name        string
+	probeOutput string
+	want        AudioInfo
+	wantErr     bool
+}
+
+func TestParseAudioInfo(t *testing.T) {
+	tests := []ParseAudioInfoTest{
+		{
+			name:        "audio stream after cover art",
+			probeOutput: FLAC_PROBE_OUTPUT,
+			want: AudioInfo{
+				FormatName:    "flac",
+				Codec:         "flac",
+				StreamIndex:   1,
+				SampleRate:    96000,
+				Channels:      2,
+				ChannelLayout: "stereo",
+				BitDepth:      24,
+				BitRate:       3072000,
+				Duration:      312.5,
+			},
+		},
+		{
+			name: "mp3 with delayed start",
+			probeOutput: `{
+				"streams": [{"index": 0, "codec_name": "mp3", "codec_type": "audio", "sample_rate": "44100", "channels": 1,
+					"channel_layout": "mono", "bit_rate": "128000", "start_time": "0.025057", "duration": "N/A"}],
+				"format": {"format_name": "mp3", "duration": "10.5"}
+			}`,
+			want: AudioInfo{
+				FormatName:    "mp3",
+				Codec:         "mp3",
+				SampleRate:    44100,
+				Channels:      1,
+				ChannelLayout: "mono",
+				BitRate:       128000,
+				StartTime:     0.025057,
+				Duration:      10.5,
+			},
+		},
+		{
+			name:        "no audio stream",
+			probeOutput: `{"streams": [{"index": 0, "codec_type": "video"}], "format": {"duration": "1"}}`,
+			wantErr:     true,
+		},
+		{
+			name:        "invalid sample rate",
+			probeOutput: `{"streams": [{"index": 0, "codec_type": "audio", "sample_rate": "fast"}]}`,
+			wantErr:     true,
+		},
+		{
+			name:        "invalid JSON",
+			probeOutput: `{"streams": [}`,
+			wantErr:     true,
+		},
+	}
+
+	for _, parseTest := range tests {
+		t.Run(parseTest.name, func(t *testing.T) {
+			info, err := ParseAudioInfo(parseTest.probeOutput)
+			if (err != nil) != parseTest.wantErr {
+				t.Errorf("ParseAudioInfo() error = %v, wantErr %v", err, parseTest.wantErr)
+				return
+			}
+			if !parseTest.wantErr && info != parseTest.want {
+				t.Errorf("ParseAudioInfo() = %+v, want %+v", info, parseTest.want)
+			}
+		})
+	}
+}
+
+func TestAudioInfoNeeds(t *testing.T) {
+	info := AudioInfo{SampleRate: 44100, Channels: 2}
+
+	if info.NeedsResample(0) || info.NeedsResample(44100) || !info.NeedsResample(16000) {
+		t.Errorf("NeedsResample() is wrong for %+v", info)
+	}
+	if info.NeedsDownmix(0) || info.NeedsDownmix(2) || !info.NeedsDownmix(1) {
+		t.Errorf("NeedsDownmix() is wrong for %+v", info)
+	}
+}
+
+func TestNativeTranscoderProbeAudio(t *testing.T) {
+	inputFilePath := writeTestWAV(t, t.TempDir(), "tone.wav", 2, 8000, 24)
+
+	info, err := NativeTranscoder{}.ProbeAudio(context.Background(), inputFilePath)
+	if err != nil {
+		t.Fatalf("ProbeAudio() error = %v", err)
+	}
+
+	want := AudioInfo{FormatName: "wav", Codec: "pcm_s24le", SampleRate: 8000, Channels: 2, BitDepth: 24, BitRate: 8000 * 2 * 24, Duration: 2}
+	if info != want {
+		t.Errorf("ProbeAudio() = %+v, want %+v", info, want)
+	}
+
+	info, err = NativeTranscoder{}.ProbeAudio(context.Background(), filepath.Join(INPUT_DIR, NATIVE_SAMPLE_FILE_NAME))
+	if err != nil {
+		t.Fatalf("ProbeAudio() error = %v", err)
+	}
+	if info.Codec != "mp3" || info.SampleRate == 0 || info.BitRate == 0 {
+		t.Errorf("ProbeAudio() = %+v, want an mp3 stream with a sample and bit rate", info)
+	}
+}
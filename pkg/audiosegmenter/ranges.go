@@ -0,0 +1,375 @@
+package audiosegmenter
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CUE_FRAMES_PER_SECOND is the resolution of INDEX timestamps in cue sheets.
+const CUE_FRAMES_PER_SECOND = 75
+
+// LabelledRange is an annotated section of a recording, such as an alapana
+// or a kriti. An End of zero extends the range to the end of the recording.
+type LabelledRange struct {
+	Label string  `json:"label"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// ReadRanges reads labelled ranges from a cue sheet (.cue), an Audacity label
+// file (.txt) or a CSV file (.csv) depending on the extension of path.
+func ReadRanges(path string) ([]LabelledRange, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var ranges []LabelledRange
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".cue":
+		ranges, err = ParseCueSheet(file)
+	case ".txt":
+		ranges, err = ParseAudacityLabels(file)
+	case ".csv":
+		ranges, err = ParseRangesCSV(file)
+	default:
+		return nil, fmt.Errorf("unknown annotation format %q", ext)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return ranges, nil
+}
+
+// ParseCueSheet reads the tracks of a single-file cue sheet. Each track runs
+// from its INDEX 01 to the next track's, and the last one to the end of the
+// recording. Tracks without a TITLE are labelled by their number.
+func ParseCueSheet(r io.Reader) ([]LabelledRange, error) {
+	var ranges []LabelledRange
+	files := 0
+	inTrack := false
+	hasIndex := false
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := splitCueLine(strings.TrimSpace(scanner.Text()))
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "FILE":
+			files++
+			if files > 1 {
+				return nil, errors.New("cue sheets with more than one FILE are not supported")
+			}
+
+		case "TRACK":
+			if inTrack && !hasIndex {
+				return nil, fmt.Errorf("line %d: previous track has no INDEX 01", line)
+			}
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: TRACK without a number", line)
+			}
+			ranges = append(ranges, LabelledRange{Label: "track_" + fields[1]})
+			inTrack, hasIndex = true, false
+
+		case "TITLE":
+			if inTrack && len(fields) > 1 {
+				ranges[len(ranges)-1].Label = fields[1]
+			}
+
+		case "INDEX":
+			if !inTrack || len(fields) < 3 || fields[1] != "01" {
+				continue
+			}
+			start, err := parseCueTimestamp(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			ranges[len(ranges)-1].Start = start
+			hasIndex = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if inTrack && !hasIndex {
+		return nil, errors.New("last track has no INDEX 01")
+	}
+
+	for i := 0; i+1 < len(ranges); i++ {
+		ranges[i].End = ranges[i+1].Start
+	}
+
+	return ranges, nil
+}
+
+// splitCueLine splits a cue sheet line on spaces, keeping quoted strings
+// together without their quotes.
+func splitCueLine(line string) []string {
+	var fields []string
+	for len(line) > 0 {
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return append(fields, line[1:])
+			}
+			fields = append(fields, line[1:end+1])
+			line = strings.TrimSpace(line[end+2:])
+			continue
+		}
+
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			return append(fields, line)
+		}
+		fields = append(fields, line[:end])
+		line = strings.TrimSpace(line[end:])
+	}
+	return fields
+}
+
+// parseCueTimestamp parses mm:ss:ff, where ff counts 1/75 second frames.
+func parseCueTimestamp(value string) (float64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid cue timestamp %q", value)
+	}
+
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid cue timestamp %q", value)
+		}
+		numbers[i] = n
+	}
+
+	if numbers[1] >= 60 || numbers[2] >= CUE_FRAMES_PER_SECOND {
+		return 0, fmt.Errorf("invalid cue timestamp %q", value)
+	}
+
+	return float64(numbers[0]*60+numbers[1]) + float64(numbers[2])/CUE_FRAMES_PER_SECOND, nil
+}
+
+// ParseAudacityLabels reads an Audacity label track export: one label per
+// line as start, end and text separated by tabs. Point labels, whose start
+// and end are equal, have no range to cut and are rejected.
+func ParseAudacityLabels(r io.Reader) ([]LabelledRange, error) {
+	var ranges []LabelledRange
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		// Spectral selections are exported on a continuation line starting
+		// with a backslash.
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "\\") {
+			continue
+		}
+
+		fields := strings.SplitN(text, "\t", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected tab separated start and end", line)
+		}
+
+		labelled := LabelledRange{}
+		var err error
+		if labelled.Start, err = strconv.ParseFloat(strings.TrimSpace(fields[0]), 64); err != nil {
+			return nil, fmt.Errorf("line %d: invalid start: %w", line, err)
+		}
+		if labelled.End, err = strconv.ParseFloat(strings.TrimSpace(fields[1]), 64); err != nil {
+			return nil, fmt.Errorf("line %d: invalid end: %w", line, err)
+		}
+		if len(fields) == 3 {
+			labelled.Label = strings.TrimSpace(fields[2])
+		}
+
+		if !(labelled.Start < labelled.End) || math.IsInf(labelled.End, 1) {
+			return nil, fmt.Errorf("line %d: label %q does not cover a range", line, labelled.Label)
+		}
+
+		ranges = append(ranges, labelled)
+	}
+
+	return ranges, scanner.Err()
+}
+
+// ParseRangesCSV reads start, end and label columns. A header row naming
+// them may put the columns in any order; without one they are taken in that
+// order. Times are seconds or [hh:]mm:ss[.fff] timestamps, and an empty end
+// runs to the start of the next row.
+func ParseRangesCSV(r io.Reader) ([]LabelledRange, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	// lines holds the line each record starts on, for error messages.
+	var records [][]string
+	var lines []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{"start": 0, "end": 1, "label": 2}
+	if _, err := parseTimestamp(records[0][0]); err != nil {
+		columns = map[string]int{}
+		for i, name := range records[0] {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		if _, ok := columns["start"]; !ok {
+			return nil, errors.New("CSV header has no start column")
+		}
+		records, lines = records[1:], lines[1:]
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	ranges := make([]LabelledRange, 0, len(records))
+	openEnded := map[int]bool{}
+
+	for row, record := range records {
+		labelled := LabelledRange{Label: field(record, "label")}
+
+		var err error
+		if labelled.Start, err = parseTimestamp(field(record, "start")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid start: %w", lines[row], err)
+		}
+
+		if end := field(record, "end"); end != "" {
+			if labelled.End, err = parseTimestamp(end); err != nil {
+				return nil, fmt.Errorf("line %d: invalid end: %w", lines[row], err)
+			}
+			if labelled.End <= labelled.Start {
+				return nil, fmt.Errorf("line %d: range %q ends before it starts", lines[row], labelled.Label)
+			}
+		} else {
+			openEnded[row] = true
+		}
+
+		ranges = append(ranges, labelled)
+	}
+
+	for row := range openEnded {
+		if row+1 < len(ranges) {
+			ranges[row].End = ranges[row+1].Start
+		}
+	}
+
+	return ranges, nil
+}
+
+var timestampPattern = regexp.MustCompile(`^(?:(\d+):)?(\d+):(\d+(?:\.\d+)?)$`)
+
+// parseTimestamp parses seconds or an [hh:]mm:ss[.fff] timestamp. Minutes
+// may run past 59 only when no hours are given, as in 75:30.
+func parseTimestamp(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+
+	if match := timestampPattern.FindStringSubmatch(value); match != nil {
+		hours := 0.0
+		if match[1] != "" {
+			hours, _ = strconv.ParseFloat(match[1], 64)
+		}
+		minutes, _ := strconv.ParseFloat(match[2], 64)
+		seconds, _ := strconv.ParseFloat(match[3], 64)
+		if seconds >= 60 || (match[1] != "" && minutes >= 60) {
+			return 0, fmt.Errorf("invalid timestamp %q", value)
+		}
+		return hours*3600 + minutes*60 + seconds, nil
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+	if seconds < 0 {
+		return 0, fmt.Errorf("negative timestamp %q", value)
+	}
+
+	return seconds, nil
+}
+
+// RangeBoundaries turns ranges into boundaries over a source of the given
+// duration. Open-ended ranges run to the end of the source and ranges that
+// run past it are clamped.
+func RangeBoundaries(ranges []LabelledRange, duration float64) ([]SegmentBoundary, error) {
+	boundaries := make([]SegmentBoundary, 0, len(ranges))
+
+	for i, labelled := range ranges {
+		end := labelled.End
+		if end == 0 || end > duration {
+			end = duration
+		}
+
+		if !(labelled.Start >= 0 && labelled.Start < end) {
+			return nil, fmt.Errorf("range %d (%q) from %v to %v is outside the %v second source", i, labelled.Label, labelled.Start, labelled.End, duration)
+		}
+
+		boundaries = append(boundaries, SegmentBoundary{
+			Index: i,
+			Start: labelled.Start,
+			End:   end,
+			Label: labelled.Label,
+		})
+	}
+
+	return boundaries, nil
+}
+
+var unsafeLabelCharacters = regexp.MustCompile(`[^\p{L}\p{N}-]+`)
+
+// labelSuffix makes label safe to use in a file name.
+func labelSuffix(label string) string {
+	return strings.Trim(unsafeLabelCharacters.ReplaceAllString(label, "_"), "_")
+}
+
+// SegmentAudioByRanges cuts every labelled range out of inputFilePath. Each
+// label is appended to its segment's file name and recorded in the results
+// and manifest. opts.SegmentDuration, HopDuration and the tail policy do not
+// apply, since the ranges decide where the cuts are.
+func SegmentAudioByRanges(ctx context.Context, inputFilePath string, outputDir string, ranges []LabelledRange, opts SegmentOptions) ([]Segment, error) {
+	if len(ranges) == 0 {
+		return nil, errors.New("no ranges to cut")
+	}
+
+	opts, err := opts.resolve(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return segmentSource(ctx, inputFilePath, outputDir, opts, nil, func(duration float64) ([]SegmentBoundary, error) {
+		return RangeBoundaries(ranges, duration)
+	})
+}
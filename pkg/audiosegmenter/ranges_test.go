@@ -0,0 +1,278 @@
+package audiosegmenter
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const CUE_SHEET = `REM GENRE Carnatic
+PERFORMER "M Balamuralikrishna"
+TITLE "Music Academy 1978"
+FILE "concert.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "alapana"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "tanam"
+    INDEX 00 04:10:00
+    INDEX 01 04:12:30
+  TRACK 03 AUDIO
+    INDEX 01 09:00:74
+`
+
+type ParseRangesTest struct {
+	name    string
+	parse   func(string) ([]LabelledRange, error)
+	input   string
+	want    []LabelledRange
+	wantErr bool
+}
+
+func TestParseRangesCSV_ErrorLine(t *testing.T) {
+	// Lines count the header and the blank lines the reader skips.
+	_, err := ParseRangesCSV(strings.NewReader("start,end,label\n0,60,alapana\n\n90,30,kriti\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 4:") {
+		t.Errorf("ParseRangesCSV() error = %v, want it on line 4", err)
+	}
+
+	_, err = ParseRangesCSV(strings.NewReader("0,60,alapana\nsoon,90,kriti\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("ParseRangesCSV() error = %v, want it on line 2", err)
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	cue := func(input string) ([]LabelledRange, error) { return ParseCueSheet(strings.NewReader(input)) }
+	audacity := func(input string) ([]LabelledRange, error) { return ParseAudacityLabels(strings.NewReader(input)) }
+	csv := func(input string) ([]LabelledRange, error) { return ParseRangesCSV(strings.NewReader(input)) }
+
+	tests := []ParseRangesTest{
+		{
+			name:  "cue sheet",
+			parse: cue,
+			input: CUE_SHEET,
+			want: []LabelledRange{
+				{Label: "alapana", Start: 0, End: 252.4},
+				{Label: "tanam", Start: 252.4, End: 540 + 74.0/75},
+				{Label: "track_03", Start: 540 + 74.0/75},
+			},
+		},
+		{
+			name:    "cue sheet with several files",
+			parse:   cue,
+			input:   "FILE \"a.wav\" WAVE\nFILE \"b.wav\" WAVE\n",
+			wantErr: true,
+		},
+		{
+			name:    "cue track without index",
+			parse:   cue,
+			input:   "TRACK 01 AUDIO\n  TITLE \"kriti\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid cue timestamp",
+			parse:   cue,
+			input:   "TRACK 01 AUDIO\n  INDEX 01 00:61:00\n",
+			wantErr: true,
+		},
+		{
+			name:  "audacity labels",
+			parse: audacity,
+			input: "0.000000\t251.250000\talapana\n\\\t100.0\t2000.0\n251.250000\t600.5\tkriti: nagumOmu\r\n600.5\t700\t\n",
+			want: []LabelledRange{
+				{Label: "alapana", Start: 0, End: 251.25},
+				{Label: "kriti: nagumOmu", Start: 251.25, End: 600.5},
+				{Label: "", Start: 600.5, End: 700},
+			},
+		},
+		{
+			name:    "audacity point label",
+			parse:   audacity,
+			input:   "12.5\t12.5\tapplause\n",
+			wantErr: true,
+		},
+		{
+			name:  "csv with header",
+			parse: csv,
+			input: "label,start,end\nalapana,0,4:12.4\nswarakalpana,1:02:03.5,\n",
+			want: []LabelledRange{
+				{Label: "alapana", Start: 0, End: 252.4},
+				{Label: "swarakalpana", Start: 3723.5},
+			},
+		},
+		{
+			name:  "csv without header",
+			parse: csv,
+			input: "0,60,alapana\n60,,tanam\n90,120,kriti\n",
+			want: []LabelledRange{
+				{Label: "alapana", Start: 0, End: 60},
+				{Label: "tanam", Start: 60, End: 90},
+				{Label: "kriti", Start: 90, End: 120},
+			},
+		},
+		{
+			name:    "csv range ending before it starts",
+			parse:   csv,
+			input:   "start,end,label\n60,30,kriti\n",
+			wantErr: true,
+		},
+		{
+			name:    "audacity label without a finite range",
+			parse:   audacity,
+			input:   "nan\tinf\tkriti\n",
+			wantErr: true,
+		},
+		{
+			name:    "csv seconds past 59",
+			parse:   csv,
+			input:   "0,1:75,alapana\n",
+			wantErr: true,
+		},
+		{
+			name:    "csv minutes past 59 with hours",
+			parse:   csv,
+			input:   "0,1:75:00,alapana\n",
+			wantErr: true,
+		},
+		{
+			name:  "csv minutes past 59 without hours",
+			parse: csv,
+			input: "0,75:30,alapana\n",
+			want:  []LabelledRange{{Label: "alapana", Start: 0, End: 4530}},
+		},
+		{
+			name:    "csv start not a number",
+			parse:   csv,
+			input:   "NaN,60,alapana\n",
+			wantErr: true,
+		},
+		{
+			name:    "csv infinite end",
+			parse:   csv,
+			input:   "0,Inf,alapana\n",
+			wantErr: true,
+		},
+		{
+			name:    "csv header without start",
+			parse:   csv,
+			input:   "from,to,label\n0,30,kriti\n",
+			wantErr: true,
+		},
+	}
+
+	for _, parseTest := range tests {
+		t.Run(parseTest.name, func(t *testing.T) {
+			ranges, err := parseTest.parse(parseTest.input)
+			if (err != nil) != parseTest.wantErr {
+				t.Errorf("parse error = %v, wantErr %v", err, parseTest.wantErr)
+				return
+			}
+			if !parseTest.wantErr && !reflect.DeepEqual(ranges, parseTest.want) {
+				t.Errorf("parse = %v, want %v", ranges, parseTest.want)
+			}
+		})
+	}
+}
+
+func TestReadRanges(t *testing.T) {
+	dir := t.TempDir()
+	cuePath := filepath.Join(dir, "concert.cue")
+	if err := os.WriteFile(cuePath, []byte(CUE_SHEET), 0644); err != nil {
+		t.Fatalf("Failed to write cue sheet: %v", err)
+	}
+
+	ranges, err := ReadRanges(cuePath)
+	if err != nil || len(ranges) != 3 {
+		t.Errorf("ReadRanges() = %v, %v", ranges, err)
+	}
+
+	if _, err := ReadRanges(filepath.Join(dir, "concert.srt")); err == nil {
+		t.Errorf("Expected an unknown annotation format to be rejected")
+	}
+}
+
+func TestRangeBoundaries(t *testing.T) {
+	boundaries, err := RangeBoundaries([]LabelledRange{
+		{Label: "alapana", Start: 0, End: 60},
+		{Label: "kriti", Start: 60},
+		{Label: "tani", Start: 80, End: 200},
+	}, 100)
+	if err != nil {
+		t.Fatalf("RangeBoundaries() error = %v", err)
+	}
+
+	want := []SegmentBoundary{
+		{Index: 0, Start: 0, End: 60, Label: "alapana"},
+		{Index: 1, Start: 60, End: 100, Label: "kriti"},
+		{Index: 2, Start: 80, End: 100, Label: "tani"},
+	}
+	if !reflect.DeepEqual(boundaries, want) {
+		t.Errorf("RangeBoundaries() = %v, want %v", boundaries, want)
+	}
+
+	if _, err := RangeBoundaries([]LabelledRange{{Label: "mangalam", Start: 120}}, 100); err == nil {
+		t.Errorf("Expected a range past the end of the source to be rejected")
+	}
+	if _, err := RangeBoundaries([]LabelledRange{{Label: "mangalam", Start: math.NaN()}}, 100); err == nil {
+		t.Errorf("Expected a range starting at NaN to be rejected")
+	}
+}
+
+func TestSegmentAudioByRanges(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 100)
+	outputDir := t.TempDir()
+
+	segments, err := SegmentAudioByRanges(context.Background(), inputFilePath, outputDir, []LabelledRange{
+		{Label: "alapana", Start: 0, End: 42.5},
+		{Label: "kriti: nagumOmu", Start: 42.5},
+	}, SegmentOptions{CutOptions: CutOptions{Transcoder: transcoder}, SegmentDuration: 30, TailPolicy: TAIL_DROP})
+	if err != nil {
+		t.Fatalf("SegmentAudioByRanges() error = %v", err)
+	}
+
+	for _, err := range SegmentErrors(segments) {
+		t.Errorf("Error while segmenting audio: %v", err)
+	}
+
+	wantNames := []string{"concert_seg_0_alapana.mp3", "concert_seg_1_kriti_nagumOmu.mp3"}
+	wantDurations := []float64{42.5, 57.5}
+	if len(segments) != len(wantNames) {
+		t.Fatalf("SegmentAudioByRanges() returned %d segments, want %d", len(segments), len(wantNames))
+	}
+
+	for i, segment := range segments {
+		if filepath.Base(segment.OutputPath) != wantNames[i] {
+			t.Errorf("Segment %d written to %s, want %s", i, segment.OutputPath, wantNames[i])
+		}
+		if segment.ActualDuration != wantDurations[i] {
+			t.Errorf("Segment %d duration = %v, want %v", i, segment.ActualDuration, wantDurations[i])
+		}
+	}
+
+	jsonPath, _ := ManifestPaths(inputFilePath, outputDir)
+	manifest, err := ReadManifest(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.Segments[1].Label != "kriti: nagumOmu" || manifest.Segments[1].OutputPath != "concert_seg_1_kriti_nagumOmu/concert_seg_1_kriti_nagumOmu.mp3" {
+		t.Errorf("Manifest entry = %+v", manifest.Segments[1])
+	}
+
+	// Relabelling a range cuts it again under its new name.
+	segments, err = SegmentAudioByRanges(context.Background(), inputFilePath, outputDir, []LabelledRange{
+		{Label: "alapana", Start: 0, End: 42.5},
+		{Label: "kriti", Start: 42.5},
+	}, SegmentOptions{CutOptions: CutOptions{Transcoder: transcoder}})
+	if err != nil {
+		t.Fatalf("SegmentAudioByRanges() error = %v", err)
+	}
+	if !segments[0].Reused || segments[1].Reused || filepath.Base(segments[1].OutputPath) != "concert_seg_1_kriti.mp3" {
+		t.Errorf("Unexpected segments after relabelling: %+v", segments)
+	}
+}
@@ -0,0 +1,268 @@
+package audiosegmenter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CHECKPOINT_INTERVAL is how often a run at most rewrites its JSON manifest
+// while segments complete. A run killed outright loses at most the segments
+// of the last interval, which are then cut again.
+const CHECKPOINT_INTERVAL = 5 * time.Second
+
+// segmentRun tracks a single SegmentAudio or SegmentAudioOnSilence call. It
+// checkpoints the JSON manifest as segments complete, so that an interrupted
+// run leaves a record of the segments it finished, and it reuses the
+// segments of an earlier run over the same source with the same parameters.
+type segmentRun struct {
+	inputFilePath string
+	outputDir     string
+	opts          SegmentOptions
+	silence       *SilenceOptions
+	metadata      RecordingMetadata
+	sourceSHA256  string
+	sourceSize    int64
+	sourceModTime *time.Time
+	previous      map[int]ManifestEntry
+	// loudness is the normalization applied to the source, and
+	// previousLoudness the one measured by the run that is resumed.
+	loudness         *Loudness
+	previousLoudness *Loudness
+	// stream is set when the source was read from a stream, in which case
+	// inputFilePath is only its name.
+	stream bool
+
+	mu             sync.Mutex
+	completed      map[int]Segment
+	lastCheckpoint time.Time
+}
+
+func startSegmentRun(inputFilePath string, outputDir string, opts SegmentOptions, silence *SilenceOptions, metadata RecordingMetadata) (*segmentRun, error) {
+	run := &segmentRun{
+		inputFilePath: inputFilePath,
+		outputDir:     outputDir,
+		opts:          opts,
+		silence:       silence,
+		metadata:      metadata,
+		previous:      map[int]ManifestEntry{},
+	}
+
+	// A source the transcoder reads from somewhere other than a local file
+	// cannot be fingerprinted, so nothing is reused for it.
+	info, err := os.Stat(inputFilePath)
+	if err != nil || !info.Mode().IsRegular() {
+		return run, nil
+	}
+
+	modTime := info.ModTime()
+	run.sourceSize, run.sourceModTime = info.Size(), &modTime
+
+	// An overwriting run reuses nothing, but still hashes the source so that
+	// the run can be resumed should it be interrupted.
+	var previous Manifest
+	manifestErr := os.ErrNotExist
+	if !opts.Overwrite {
+		jsonPath, _ := ManifestPaths(inputFilePath, outputDir)
+		previous, manifestErr = ReadManifest(jsonPath)
+	}
+
+	// Hashing reads the whole source, so the hash of an earlier run is
+	// trusted while the size and modification time of the file still match.
+	if manifestErr == nil && previous.SourceSHA256 != "" && previous.SourceSize == info.Size() &&
+		previous.SourceModTime != nil && previous.SourceModTime.Equal(modTime) {
+		run.sourceSHA256 = previous.SourceSHA256
+	} else if run.sourceSHA256, err = hashFile(inputFilePath); err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", inputFilePath, err)
+	}
+
+	if manifestErr != nil || !run.matches(previous) {
+		return run, nil
+	}
+
+	for _, entry := range previous.Segments {
+		if entry.Error == "" {
+			run.previous[entry.Index] = entry
+		}
+	}
+	run.previousLoudness = previous.Loudness
+
+	return run, nil
+}
+
+// matches reports whether previous was written by a run over the same source
+// with parameters that produce the same segments.
+func (r *segmentRun) matches(previous Manifest) bool {
+	if r.sourceSHA256 == "" || previous.SourceSHA256 != r.sourceSHA256 {
+		return false
+	}
+
+	return runParameters(previous.Options, previous.Silence) == runParameters(r.opts, r.silence)
+}
+
+// runParameters serialises the parameters that affect the segments written,
+// leaving out those such as the concurrency that only affect how.
+func runParameters(opts SegmentOptions, silence *SilenceOptions) string {
+	opts.Concurrency = 0
+
+	data, err := json.Marshal(struct {
+		Options SegmentOptions  `json:"options"`
+		Silence *SilenceOptions `json:"silence"`
+	}{opts, silence})
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+// reuse returns the segment an earlier run wrote for boundary, provided its
+// output is still on disk unchanged.
+func (r *segmentRun) reuse(boundary SegmentBoundary) (Segment, bool) {
+	if r == nil {
+		return Segment{}, false
+	}
+
+	entry, ok := r.previous[boundary.Index]
+	if !ok || entry.Start != boundary.Start || entry.RequestedDuration != boundary.Duration() || entry.Tail != boundary.Tail || entry.Label != boundary.Label {
+		return Segment{}, false
+	}
+
+	outputFilePath := filepath.Join(r.outputDir, filepath.FromSlash(entry.OutputPath))
+	info, err := os.Stat(outputFilePath)
+	if err != nil || info.Size() != entry.Size {
+		return Segment{}, false
+	}
+
+	if hash, err := hashFile(outputFilePath); err != nil || hash != entry.SHA256 {
+		return Segment{}, false
+	}
+
+	return Segment{
+		Index:             entry.Index,
+		SourcePath:        r.inputFilePath,
+		OutputPath:        outputFilePath,
+		Start:             entry.Start,
+		RequestedDuration: entry.RequestedDuration,
+		ActualDuration:    entry.ActualDuration,
+		Size:              entry.Size,
+		SHA256:            entry.SHA256,
+		Output:            r.opts.Output,
+		Label:             entry.Label,
+		Tail:              entry.Tail,
+		Reused:            true,
+	}, true
+}
+
+// complete records the segment for boundary i and, unless the last
+// checkpoint is less than CHECKPOINT_INTERVAL old, rewrites the JSON
+// manifest with every segment completed so far. Rewriting it every time
+// would write a quadratic amount for a long recording and hold up the cuts.
+func (r *segmentRun) complete(i int, segment Segment) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.completed == nil {
+		r.completed = map[int]Segment{}
+	}
+	r.completed[i] = r.label(segment)
+
+	if time.Since(r.lastCheckpoint) < CHECKPOINT_INTERVAL {
+		return nil
+	}
+	r.lastCheckpoint = time.Now()
+
+	positions := make([]int, 0, len(r.completed))
+	for position := range r.completed {
+		positions = append(positions, position)
+	}
+	sort.Ints(positions)
+
+	completed := make([]Segment, 0, len(positions))
+	for _, position := range positions {
+		completed = append(completed, r.completed[position])
+	}
+
+	jsonPath, _ := ManifestPaths(r.inputFilePath, r.outputDir)
+	return writeManifestJSON(jsonPath, r.manifest(completed))
+}
+
+func (r *segmentRun) label(segment Segment) Segment {
+	if !r.metadata.IsEmpty() {
+		metadata := r.metadata
+		segment.Metadata = &metadata
+	}
+	segment.Loudness = r.loudness
+	return segment
+}
+
+func (r *segmentRun) manifest(segments []Segment) Manifest {
+	manifest := NewManifest(r.inputFilePath, r.outputDir, r.opts, segments)
+	manifest.SourceSHA256 = r.sourceSHA256
+	manifest.SourceSize = r.sourceSize
+	manifest.SourceModTime = r.sourceModTime
+	manifest.Silence = r.silence
+	manifest.Loudness = r.loudness
+
+	if r.stream {
+		manifest.SourcePath = r.inputFilePath
+		for i := range manifest.Segments {
+			manifest.Segments[i].SourcePath = r.inputFilePath
+		}
+	}
+
+	return manifest
+}
+
+// finish labels segments with the recording metadata and loudness and writes
+// the final JSON and CSV manifests.
+func (r *segmentRun) finish(segments []Segment) error {
+	for i := range segments {
+		segments[i] = r.label(segments[i])
+	}
+
+	return WriteManifest(r.inputFilePath, r.outputDir, r.manifest(segments))
+}
+
+// partialPath returns the file a segment is written to before being renamed
+// to outputFilePath. It keeps the extension so that ffmpeg picks the same
+// muxer, and it is fixed so that a resumed run overwrites what an interrupted
+// one left behind.
+func partialPath(outputFilePath string) string {
+	dir, base := filepath.Split(outputFilePath)
+	ext := filepath.Ext(base)
+	return filepath.Join(dir, "."+base[:len(base)-len(ext)]+".partial"+ext)
+}
+
+// writeFileAtomic writes path through a temporary file in the same directory
+// and renames it into place, so readers never see a partial file.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
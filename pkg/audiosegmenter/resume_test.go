@@ -0,0 +1,298 @@
+package audiosegmenter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func countReused(segments []Segment) int {
+	reused := 0
+	for _, segment := range segments {
+		if segment.Reused {
+			reused++
+		}
+	}
+	return reused
+}
+
+func TestSegmentAudio_ReusesPreviousRun(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 95)
+	outputDir := t.TempDir()
+	opts := SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 30,
+		Concurrency:     2,
+	}
+
+	first, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	// The concurrency does not change the segments written.
+	opts.Concurrency = 4
+	second, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	if len(transcoder.cuts) != len(first) {
+		t.Errorf("Second run cut %d segments again", len(transcoder.cuts)-len(first))
+	}
+	if countReused(second) != len(first) {
+		t.Errorf("Second run reused %d of %d segments", countReused(second), len(first))
+	}
+	for i := range second {
+		if second[i].OutputPath != first[i].OutputPath || second[i].SHA256 != first[i].SHA256 || second[i].ActualDuration != first[i].ActualDuration {
+			t.Errorf("Reused segment %d = %+v, want %+v", i, second[i], first[i])
+		}
+	}
+
+	// Tampering with an output gets that segment cut again.
+	if err := os.WriteFile(first[1].OutputPath, []byte("truncated"), 0644); err != nil {
+		t.Fatalf("Failed to modify segment: %v", err)
+	}
+	third, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if third[1].Reused || countReused(third) != len(first)-1 {
+		t.Errorf("Expected only the modified segment to be cut again, reused %d", countReused(third))
+	}
+
+	opts.Overwrite = true
+	fourth, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if countReused(fourth) != 0 {
+		t.Errorf("Overwrite reused %d segments", countReused(fourth))
+	}
+}
+
+func TestSegmentAudio_ChangedRunIsCutAgain(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 60)
+	outputDir := t.TempDir()
+	opts := SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 30,
+	}
+
+	if _, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts); err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	opts.Output = OutputSpec{SampleRate: 16000}
+	segments, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if countReused(segments) != 0 {
+		t.Errorf("Reused %d segments cut with a different output spec", countReused(segments))
+	}
+
+	if err := os.WriteFile(inputFilePath, []byte("a new recording"), 0644); err != nil {
+		t.Fatalf("Failed to replace source: %v", err)
+	}
+	segments, err = SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if countReused(segments) != 0 {
+		t.Errorf("Reused %d segments of a different source", countReused(segments))
+	}
+}
+
+func TestSegmentAudio_ResumesInterruptedRun(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 60)
+	transcoder.blockAt[30] = true
+	outputDir := t.TempDir()
+	opts := SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 10,
+		Concurrency:     1,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	interrupted, err := SegmentAudio(ctx, inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if SegmentErrors(interrupted) == nil || !errors.Is(interrupted[3].Err, context.Canceled) {
+		t.Fatalf("Expected the run to be interrupted at segment 3, got %v", SegmentErrors(interrupted))
+	}
+
+	// Nothing is left under the final name of the interrupted segment.
+	if _, err := os.Stat(interrupted[3].OutputPath); !os.IsNotExist(err) {
+		t.Errorf("Interrupted segment left %s behind: %v", interrupted[3].OutputPath, err)
+	}
+
+	transcoder.blockAt[30] = false
+	resumed, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	for _, err := range SegmentErrors(resumed) {
+		t.Errorf("Error while resuming: %v", err)
+	}
+	if countReused(resumed) != 3 {
+		t.Errorf("Resumed run reused %d segments, want the 3 finished before the interruption", countReused(resumed))
+	}
+
+	err = filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
+		if strings.Contains(info.Name(), ".partial") || strings.HasSuffix(info.Name(), ".tmp") {
+			t.Errorf("Temporary file %s left behind", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk output directory: %v", err)
+	}
+}
+
+func TestSegmentAudio_SourceFingerprint(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 60)
+	outputDir := t.TempDir()
+	opts := SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 30,
+	}
+	jsonPath, _ := ManifestPaths(inputFilePath, outputDir)
+
+	if _, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts); err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	manifest, err := ReadManifest(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.SourceSHA256 == "" || manifest.SourceSize == 0 || manifest.SourceModTime == nil {
+		t.Fatalf("Manifest does not fingerprint the source: %+v", manifest)
+	}
+
+	// The hash is trusted, without reading the source again, while its size
+	// and modification time are unchanged.
+	info, _ := os.Stat(inputFilePath)
+	content, _ := os.ReadFile(inputFilePath)
+	for i, j := 0, len(content)-1; i < j; i, j = i+1, j-1 {
+		content[i], content[j] = content[j], content[i]
+	}
+	if err := os.WriteFile(inputFilePath, content, 0644); err != nil {
+		t.Fatalf("Failed to rewrite source: %v", err)
+	}
+	os.Chtimes(inputFilePath, info.ModTime(), info.ModTime())
+
+	segments, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if countReused(segments) != len(segments) {
+		t.Errorf("Reused %d of %d segments of an unchanged source", countReused(segments), len(segments))
+	}
+
+	// A newer source is hashed again and found to have changed.
+	later := info.ModTime().Add(time.Hour)
+	os.Chtimes(inputFilePath, later, later)
+	segments, err = SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if countReused(segments) != 0 {
+		t.Errorf("Reused %d segments of a changed source", countReused(segments))
+	}
+
+	// Overwriting reuses nothing, but still fingerprints the source so that
+	// a later run can resume from it.
+	opts.Overwrite = true
+	if _, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts); err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if manifest, _ := ReadManifest(jsonPath); manifest.SourceSHA256 == "" {
+		t.Error("Overwriting run did not hash the source")
+	}
+
+	opts.Overwrite = false
+	segments, err = SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if countReused(segments) != len(segments) {
+		t.Errorf("Reused %d of %d segments written by an overwriting run", countReused(segments), len(segments))
+	}
+}
+
+func TestSegmentRun_ThrottlesCheckpoints(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 1000)
+	outputDir := t.TempDir()
+	jsonPath, _ := ManifestPaths(inputFilePath, outputDir)
+
+	run, err := startSegmentRun(inputFilePath, outputDir, SegmentOptions{SegmentDuration: 1}, nil, RecordingMetadata{})
+	if err != nil {
+		t.Fatalf("startSegmentRun() error = %v", err)
+	}
+
+	segments := make([]Segment, 1000)
+	for i := range segments {
+		segments[i] = Segment{Index: i, Start: float64(i), RequestedDuration: 1, ActualDuration: 1}
+		if err := run.complete(i, segments[i]); err != nil {
+			t.Fatalf("complete(%d) error = %v", i, err)
+		}
+	}
+
+	// Only the first segment is checkpointed within the interval.
+	manifest, err := ReadManifest(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read checkpoint: %v", err)
+	}
+	if len(manifest.Segments) != 1 {
+		t.Errorf("Checkpoint holds %d segments, want 1", len(manifest.Segments))
+	}
+
+	if err := run.finish(segments); err != nil {
+		t.Fatalf("finish() error = %v", err)
+	}
+	if manifest, _ := ReadManifest(jsonPath); len(manifest.Segments) != len(segments) {
+		t.Errorf("Final manifest holds %d segments, want %d", len(manifest.Segments), len(segments))
+	}
+}
+
+func TestSegmentAudio_SourceNotOnDisk(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	transcoder.durations["concert.mp3"] = 60
+	outputDir := t.TempDir()
+	opts := SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 30,
+	}
+
+	for run := 0; run < 2; run++ {
+		segments, err := SegmentAudio(context.Background(), "concert.mp3", outputDir, opts)
+		if err != nil {
+			t.Fatalf("SegmentAudio() error = %v", err)
+		}
+		if len(segments) != 2 || countReused(segments) != 0 {
+			t.Errorf("Run %d cut %d segments and reused %d", run, len(segments), countReused(segments))
+		}
+	}
+}
+
+func TestPartialPath(t *testing.T) {
+	got := partialPath(filepath.Join("out", "concert_seg_1", "concert_seg_1.mp3"))
+	want := filepath.Join("out", "concert_seg_1", ".concert_seg_1.partial.mp3")
+	if got != want {
+		t.Errorf("partialPath() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,204 @@
+package audiosegmenter
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+type SilenceOptions struct {
+	// Tolerance is how far, in seconds, a boundary may move from its nominal position.
+	Tolerance float64 `json:"tolerance"`
+	// FrameDuration is the length, in seconds, of each RMS envelope frame.
+	FrameDuration float64 `json:"frame_duration"`
+	// SampleRate is the approximate rate the input is decoded at for analysis.
+	SampleRate int `json:"sample_rate"`
+	// SilenceThresholdDB is the level, in dBFS, below which a frame counts as silent.
+	SilenceThresholdDB float64 `json:"silence_threshold_db"`
+	// MaxSilenceRatio is the largest fraction of silent frames a segment may contain before it is dropped.
+	MaxSilenceRatio float64 `json:"max_silence_ratio"`
+}
+
+func DefaultSilenceOptions() SilenceOptions {
+	return SilenceOptions{
+		Tolerance:          3,
+		FrameDuration:      0.05,
+		SampleRate:         8000,
+		SilenceThresholdDB: -45,
+		MaxSilenceRatio:    0.8,
+	}
+}
+
+// Validate reports options that cannot analyse the input, such as the zero
+// value; start from DefaultSilenceOptions instead.
+func (opts SilenceOptions) Validate() error {
+	if !(opts.Tolerance >= 0) || math.IsInf(opts.Tolerance, 1) {
+		return fmt.Errorf("silence tolerance must be finite and not negative, got %v", opts.Tolerance)
+	}
+	if opts.SampleRate <= 0 {
+		return fmt.Errorf("silence analysis sample rate must be positive, got %d", opts.SampleRate)
+	}
+	if !(opts.FrameDuration > 0) || math.IsInf(opts.FrameDuration, 1) || int(float64(opts.SampleRate)*opts.FrameDuration) <= 0 {
+		return fmt.Errorf("silence frame duration must span at least one sample at %d Hz, got %v", opts.SampleRate, opts.FrameDuration)
+	}
+	if math.IsNaN(opts.SilenceThresholdDB) || opts.SilenceThresholdDB > 0 {
+		return fmt.Errorf("silence threshold must be at most 0 dBFS, got %v", opts.SilenceThresholdDB)
+	}
+	if !(opts.MaxSilenceRatio > 0 && opts.MaxSilenceRatio <= 1) {
+		return fmt.Errorf("max silence ratio must be above 0 and at most 1, got %v", opts.MaxSilenceRatio)
+	}
+	return nil
+}
+
+// ComputeRMSEnvelope decodes inputFilePath to mono with transcoder and
+// returns the RMS of every frameDuration seconds of it.
+func ComputeRMSEnvelope(ctx context.Context, transcoder Transcoder, inputFilePath string, sampleRate int, frameDuration float64) ([]float64, error) {
+	pcm, err := transcoder.DecodeMono(ctx, inputFilePath, sampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s for analysis: %w", inputFilePath, err)
+	}
+
+	frameSize := int(float64(pcm.SampleRate) * frameDuration)
+	if frameSize <= 0 {
+		return nil, fmt.Errorf("frame duration %v is too short for sample rate %d", frameDuration, pcm.SampleRate)
+	}
+
+	return rmsEnvelope(pcm.Samples, frameSize), nil
+}
+
+// rmsEnvelope returns the RMS of each frameSize block of samples, normalised
+// so that a full-scale signal has an RMS of 1.
+func rmsEnvelope(samples []int16, frameSize int) []float64 {
+	envelope := make([]float64, 0, (len(samples)+frameSize-1)/frameSize)
+
+	for start := 0; start < len(samples); start += frameSize {
+		end := min(start+frameSize, len(samples))
+
+		var sum float64
+		for _, sample := range samples[start:end] {
+			v := float64(sample) / math.MaxInt16
+			sum += v * v
+		}
+		envelope = append(envelope, math.Sqrt(sum/float64(end-start)))
+	}
+
+	return envelope
+}
+
+func amplitudeToDB(amplitude float64) float64 {
+	if amplitude <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(amplitude)
+}
+
+// SnapBoundaries moves every nominal cut point, placed each segmentDuration
+// seconds, to the quietest envelope frame within tolerance seconds of it and
+// returns the resulting segments. Ties are broken towards the nominal cut so
+// that silence-free input keeps its fixed-length boundaries.
+func SnapBoundaries(envelope []float64, frameDuration float64, duration float64, segmentDuration float64, tolerance float64) []SegmentBoundary {
+	if segmentDuration <= 0 || duration <= 0 {
+		return nil
+	}
+
+	var boundaries []SegmentBoundary
+	start := 0.0
+
+	for k := 1; float64(k)*segmentDuration < duration; k++ {
+		nominal := float64(k) * segmentDuration
+		cut := nominal
+
+		lo := max(nominal-tolerance, start)
+		hi := min(nominal+tolerance, duration)
+		bestEnergy := math.Inf(1)
+		if i := int(nominal / frameDuration); i < len(envelope) {
+			bestEnergy = envelope[i]
+		}
+
+		for i := int(lo / frameDuration); i < len(envelope); i++ {
+			frameTime := (float64(i) + 0.5) * frameDuration
+			if frameTime > hi {
+				break
+			}
+			if frameTime <= lo {
+				continue
+			}
+
+			energy := envelope[i]
+			if energy < bestEnergy || (energy == bestEnergy && math.Abs(frameTime-nominal) < math.Abs(cut-nominal)) {
+				bestEnergy = energy
+				cut = frameTime
+			}
+		}
+
+		if cut <= start {
+			continue
+		}
+
+		boundaries = append(boundaries, SegmentBoundary{Index: len(boundaries), Start: start, End: cut})
+		start = cut
+	}
+
+	boundaries = append(boundaries, SegmentBoundary{Index: len(boundaries), Start: start, End: duration})
+
+	return boundaries
+}
+
+// SilenceRatio returns the fraction of envelope frames between start and end
+// seconds whose level is below thresholdDB.
+func SilenceRatio(envelope []float64, frameDuration float64, start float64, end float64, thresholdDB float64) float64 {
+	first := max(int(start/frameDuration), 0)
+	last := min(int(math.Ceil(end/frameDuration)), len(envelope))
+
+	if last <= first {
+		return 1
+	}
+
+	silent := 0
+	for _, energy := range envelope[first:last] {
+		if amplitudeToDB(energy) < thresholdDB {
+			silent++
+		}
+	}
+
+	return float64(silent) / float64(last-first)
+}
+
+// SegmentAudioOnSilence cuts the input into segments of roughly
+// opts.SegmentDuration seconds, moving each boundary to the nearest
+// low-energy point and dropping segments that are mostly silence. Segments
+// are cut back-to-back, so opts.HopDuration is ignored. The returned
+// segments carry the boundaries that were actually used; dropped segments
+// keep their index so that the kept ones line up with the envelope analysis.
+// Like SegmentAudio it writes a manifest of the run, including the silence
+// options, to outputDir.
+func SegmentAudioOnSilence(ctx context.Context, inputFilePath string, outputDir string, opts SegmentOptions, silence SilenceOptions) ([]Segment, error) {
+	opts, err := opts.resolve(true)
+	if err != nil {
+		return nil, err
+	}
+	if err := silence.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid silence options: %w", err)
+	}
+
+	return segmentSource(ctx, inputFilePath, outputDir, opts, &silence, func(duration float64) ([]SegmentBoundary, error) {
+		envelope, err := ComputeRMSEnvelope(ctx, opts.Transcoder, inputFilePath, silence.SampleRate, silence.FrameDuration)
+
+		if err != nil {
+			return nil, err
+		}
+
+		boundaries := SnapBoundaries(envelope, silence.FrameDuration, duration, opts.SegmentDuration, silence.Tolerance)
+		boundaries = ApplyTailPolicy(boundaries, duration, opts.SegmentDuration, opts.TailPolicy, opts.MinTailDuration)
+
+		var kept []SegmentBoundary
+		for _, boundary := range boundaries {
+			if SilenceRatio(envelope, silence.FrameDuration, boundary.Start, boundary.End, silence.SilenceThresholdDB) > silence.MaxSilenceRatio {
+				continue
+			}
+			kept = append(kept, boundary)
+		}
+
+		return kept, nil
+	})
+}
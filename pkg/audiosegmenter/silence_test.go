@@ -0,0 +1,221 @@
+package audiosegmenter
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestRMSEnvelope(t *testing.T) {
+	samples := []int16{0, 0, 0, 0, math.MaxInt16, -math.MaxInt16, math.MaxInt16, -math.MaxInt16, 0}
+
+	envelope := rmsEnvelope(samples, 4)
+
+	want := []float64{0, 1, 0}
+	if len(envelope) != len(want) {
+		t.Fatalf("rmsEnvelope() returned %d frames, want %d", len(envelope), len(want))
+	}
+	for i := range want {
+		if math.Abs(envelope[i]-want[i]) > 1e-9 {
+			t.Errorf("frame %d = %v, want %v", i, envelope[i], want[i])
+		}
+	}
+}
+
+// envelopeWithDips returns a loud envelope of the given length in frames with
+// silent frames at each of the given frame indices.
+func envelopeWithDips(frames int, dips ...int) []float64 {
+	envelope := make([]float64, frames)
+	for i := range envelope {
+		envelope[i] = 0.5
+	}
+	for _, dip := range dips {
+		envelope[dip] = 0
+	}
+	return envelope
+}
+
+type SnapBoundariesTest struct {
+	name            string
+	envelope        []float64
+	duration        float64
+	segmentDuration float64
+	tolerance       float64
+	wantBoundaries  []SegmentBoundary
+}
+
+func TestSnapBoundaries(t *testing.T) {
+	// One frame per second keeps the expected frame centres readable.
+	const frameDuration = 1.0
+
+	tests := []SnapBoundariesTest{
+		{
+			name:            "no quiet point keeps nominal cuts",
+			envelope:        envelopeWithDips(30),
+			duration:        30,
+			segmentDuration: 10,
+			tolerance:       2,
+			wantBoundaries: []SegmentBoundary{
+				{Index: 0, Start: 0, End: 10},
+				{Index: 1, Start: 10, End: 20},
+				{Index: 2, Start: 20, End: 30},
+			},
+		},
+		{
+			name:            "cuts move to quiet frames within tolerance",
+			envelope:        envelopeWithDips(30, 11, 18),
+			duration:        30,
+			segmentDuration: 10,
+			tolerance:       2,
+			wantBoundaries: []SegmentBoundary{
+				{Index: 0, Start: 0, End: 11.5},
+				{Index: 1, Start: 11.5, End: 18.5},
+				{Index: 2, Start: 18.5, End: 30},
+			},
+		},
+		{
+			name:            "quiet frames outside tolerance are ignored",
+			envelope:        envelopeWithDips(30, 14),
+			duration:        30,
+			segmentDuration: 10,
+			tolerance:       2,
+			wantBoundaries: []SegmentBoundary{
+				{Index: 0, Start: 0, End: 10},
+				{Index: 1, Start: 10, End: 20},
+				{Index: 2, Start: 20, End: 30},
+			},
+		},
+		{
+			name:            "input shorter than one segment",
+			envelope:        envelopeWithDips(5),
+			duration:        5,
+			segmentDuration: 10,
+			tolerance:       2,
+			wantBoundaries: []SegmentBoundary{
+				{Index: 0, Start: 0, End: 5},
+			},
+		},
+	}
+
+	for _, snapTest := range tests {
+		t.Run(snapTest.name, func(t *testing.T) {
+			boundaries := SnapBoundaries(snapTest.envelope, frameDuration, snapTest.duration, snapTest.segmentDuration, snapTest.tolerance)
+			if len(boundaries) != len(snapTest.wantBoundaries) {
+				t.Fatalf("SnapBoundaries() = %v, want %v", boundaries, snapTest.wantBoundaries)
+			}
+			for i, want := range snapTest.wantBoundaries {
+				if boundaries[i] != want {
+					t.Errorf("boundary %d = %v, want %v", i, boundaries[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSilenceRatio(t *testing.T) {
+	envelope := []float64{0, 0, 0, 0.5, 0.5, 0, 0.5, 0.5}
+
+	if ratio := SilenceRatio(envelope, 1, 0, 4, -45); ratio != 0.75 {
+		t.Errorf("SilenceRatio() over mostly silent range = %v, want 0.75", ratio)
+	}
+
+	if ratio := SilenceRatio(envelope, 1, 3, 8, -45); ratio != 0.2 {
+		t.Errorf("SilenceRatio() over mostly loud range = %v, want 0.2", ratio)
+	}
+
+	if ratio := SilenceRatio(envelope, 1, 10, 12, -45); ratio != 1 {
+		t.Errorf("SilenceRatio() past the end of the envelope = %v, want 1", ratio)
+	}
+}
+
+type SilenceOptionsTest struct {
+	name    string
+	opts    func(opts *SilenceOptions)
+	wantErr bool
+}
+
+func TestSilenceOptions_Validate(t *testing.T) {
+	tests := []SilenceOptionsTest{
+		{name: "defaults", opts: func(opts *SilenceOptions) {}},
+		{name: "no tolerance", opts: func(opts *SilenceOptions) { opts.Tolerance = 0 }},
+		{name: "zero value", opts: func(opts *SilenceOptions) { *opts = SilenceOptions{} }, wantErr: true},
+		{name: "negative tolerance", opts: func(opts *SilenceOptions) { opts.Tolerance = -1 }, wantErr: true},
+		{name: "no sample rate", opts: func(opts *SilenceOptions) { opts.SampleRate = 0 }, wantErr: true},
+		{name: "no frame duration", opts: func(opts *SilenceOptions) { opts.FrameDuration = 0 }, wantErr: true},
+		{name: "frame below one sample", opts: func(opts *SilenceOptions) { opts.FrameDuration = 0.0001 }, wantErr: true},
+		{name: "threshold above full scale", opts: func(opts *SilenceOptions) { opts.SilenceThresholdDB = 3 }, wantErr: true},
+		{name: "threshold not a number", opts: func(opts *SilenceOptions) { opts.SilenceThresholdDB = math.NaN() }, wantErr: true},
+		{name: "no silence allowed", opts: func(opts *SilenceOptions) { opts.MaxSilenceRatio = 0 }, wantErr: true},
+		{name: "ratio above one", opts: func(opts *SilenceOptions) { opts.MaxSilenceRatio = 1.5 }, wantErr: true},
+	}
+
+	for _, optsTest := range tests {
+		t.Run(optsTest.name, func(t *testing.T) {
+			opts := DefaultSilenceOptions()
+			optsTest.opts(&opts)
+
+			err := opts.Validate()
+			if (err != nil) != optsTest.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, optsTest.wantErr)
+			}
+		})
+	}
+}
+
+func TestSegmentAudioOnSilence(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 90)
+	outputDir := t.TempDir()
+	silence := DefaultSilenceOptions()
+
+	// The middle 30 seconds of the recording are silent.
+	samples := make([]int16, 90*silence.SampleRate)
+	for i := range samples {
+		if i < 30*silence.SampleRate || i >= 60*silence.SampleRate {
+			samples[i] = 10000
+		}
+	}
+	transcoder.pcm[inputFilePath] = PCM{Samples: samples, SampleRate: silence.SampleRate, Channels: 1}
+
+	opts := SegmentOptions{CutOptions: CutOptions{Transcoder: transcoder}, SegmentDuration: 30}
+	segments, err := SegmentAudioOnSilence(context.Background(), inputFilePath, outputDir, opts, silence)
+	if err != nil {
+		t.Fatalf("SegmentAudioOnSilence() error = %v", err)
+	}
+
+	if len(segments) != 2 || segments[0].Index != 0 || segments[1].Index != 2 {
+		t.Fatalf("SegmentAudioOnSilence() = %+v, want segments 0 and 2 with the silent one dropped", segments)
+	}
+	if len(transcoder.cuts) != 2 {
+		t.Errorf("Cut %d segments, want only the 2 kept", len(transcoder.cuts))
+	}
+
+	jsonPath, _ := ManifestPaths(inputFilePath, outputDir)
+	manifest, err := ReadManifest(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.Silence == nil || *manifest.Silence != silence {
+		t.Errorf("Manifest silence options = %+v, want %+v", manifest.Silence, silence)
+	}
+	if len(manifest.Segments) != 2 {
+		t.Errorf("Manifest lists %d segments, want 2", len(manifest.Segments))
+	}
+
+	if _, err := SegmentAudioOnSilence(context.Background(), inputFilePath, t.TempDir(), opts, SilenceOptions{}); err == nil {
+		t.Errorf("Expected the zero silence options to be rejected")
+	}
+
+	for _, invalid := range []func(*SilenceOptions){
+		func(o *SilenceOptions) { o.Tolerance = math.NaN() },
+		func(o *SilenceOptions) { o.Tolerance = math.Inf(1) },
+		func(o *SilenceOptions) { o.FrameDuration = math.NaN() },
+		func(o *SilenceOptions) { o.FrameDuration = math.Inf(1) },
+	} {
+		options := DefaultSilenceOptions()
+		invalid(&options)
+		if err := options.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", options)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package audiosegmenter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hajimehoshi/go-mp3"
+
+	"raga-recog-pipeline/pkg/progress"
+)
+
+// PCMReader reads decoded audio sequentially from a source that cannot seek,
+// such as a pipe or an upload body.
+type PCMReader struct {
+	reader io.Reader
+	close  func() error
+	// decodedChannels is the number of channels reader decodes to when it
+	// is more than Channels, as for a mono MP3 decoded to stereo.
+	decodedChannels int
+	SampleRate      int
+	Channels        int
+}
+
+// ReadFrames decodes up to frames frames. It returns io.EOF once the stream
+// is exhausted and no frames were read.
+func (p *PCMReader) ReadFrames(frames int) (PCM, error) {
+	channels := p.Channels
+	if p.decodedChannels > 0 {
+		channels = p.decodedChannels
+	}
+	pcm := PCM{SampleRate: p.SampleRate, Channels: channels}
+
+	frameSize := 2 * channels
+	raw := make([]byte, frames*frameSize)
+	n, err := io.ReadFull(p.reader, raw)
+
+	pcm.Samples = bytesToSamples(raw[:n-n%frameSize])
+	if channels != p.Channels {
+		pcm = Downmix(pcm)
+	}
+	if len(pcm.Samples) > 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+		return pcm, nil
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return pcm, err
+}
+
+func (p *PCMReader) Close() error {
+	if p.close == nil {
+		return nil
+	}
+	return p.close()
+}
+
+// NewPCMReader decodes a WAV or MP3 stream with the pure-Go decoders, picked
+// by the extension of name. MP3 is decoded to stereo, except that a mono MP3
+// is read as the single channel it holds.
+func NewPCMReader(r io.Reader, name string) (*PCMReader, error) {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".wav", ".wave":
+		reader, err := newWAVPCMReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+		}
+		return &PCMReader{reader: reader, SampleRate: reader.header.sampleRate, Channels: reader.header.channels}, nil
+
+	case ".mp3":
+		// The bytes read ahead to find the header of the first frame are
+		// replayed to the decoder.
+		var head bytes.Buffer
+		channels := readMP3Channels(bufio.NewReader(io.TeeReader(r, &head)))
+
+		decoder, err := mp3.NewDecoder(io.MultiReader(&head, r))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+		}
+		return &PCMReader{reader: decoder, decodedChannels: 2, SampleRate: decoder.SampleRate(), Channels: channels}, nil
+
+	default:
+		return nil, fmt.Errorf("no pure-Go decoder for %q streams", ext)
+	}
+}
+
+func samplesToBytes(samples []int16) []byte {
+	raw := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(raw[i*2:], uint16(sample))
+	}
+	return raw
+}
+
+type streamWindow struct {
+	boundary SegmentBoundary
+	pcm      PCM
+}
+
+// SegmentAudioStream segments audio read from r, such as stdin or an upload
+// body, without writing it to a file first. name stands in for the source
+// path: it names the segments and manifest and its extension tells the
+// native backend how to decode the stream. Windows follow the same rules as
+// SegmentAudio and each is encoded as soon as its range has been read, so
+// emit, when set, is called with every segment as it is written, in
+// completion order and never concurrently. All segments are also returned in
+// index order.
+//
+// Segments are always re-encoded, streams cannot be resumed and TAIL_MERGE
+// is not supported since a window cannot be extended once it has been
+// written. Neither is loudness normalization, which needs the whole source
+// before the first segment is written. The error is set when the stream
+// could not be read to the end; the segments cut until then are still
+// returned and recorded.
+func SegmentAudioStream(ctx context.Context, r io.Reader, name string, outputDir string, opts SegmentOptions, emit func(Segment)) ([]Segment, error) {
+	opts, err := opts.resolve(true)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TailPolicy == TAIL_MERGE {
+		return nil, fmt.Errorf("the %s tail policy is not supported for streams", TAIL_MERGE)
+	}
+	if opts.Loudness != nil {
+		return nil, errors.New("loudness normalization is not supported for streams, since it is measured over the whole source")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	hash := sha256.New()
+	source := io.TeeReader(r, hash)
+
+	stream, err := opts.Transcoder.DecodeStream(ctx, source, name)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &segmentRun{
+		inputFilePath: name,
+		outputDir:     outputDir,
+		opts:          opts,
+		stream:        true,
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DEFAULT_CONCURRENCY
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		segments []Segment
+	)
+	windows := make(chan streamWindow)
+
+	tracker := progress.NewTracker(opts.Progress, 0)
+	tracker.Start(name)
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for window := range windows {
+				segment := encodeWindow(ctx, name, outputDir, opts.CutOptions, window)
+
+				if err := run.complete(window.boundary.Index, segment); err != nil && segment.Err == nil {
+					segment.Err = fmt.Errorf("error processing segment %d: %w", segment.Index, err)
+				}
+
+				mu.Lock()
+				segments = append(segments, segment)
+				if emit != nil {
+					emit(segment)
+				}
+				mu.Unlock()
+
+				tracker.Done(name)
+			}
+		}()
+	}
+
+	streamErr := windowStream(ctx, stream, opts, windows, tracker)
+	close(windows)
+	wg.Wait()
+
+	if err := stream.Close(); err != nil && streamErr == nil {
+		streamErr = err
+	}
+	if streamErr == nil {
+		// Decoders may stop before trailing tags, which still count
+		// towards the hash of the source.
+		if _, err := io.Copy(io.Discard, source); err != nil {
+			streamErr = err
+		}
+		run.sourceSHA256 = hex.EncodeToString(hash.Sum(nil))
+	}
+	if streamErr != nil {
+		streamErr = fmt.Errorf("failed to read %s: %w", name, streamErr)
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Index < segments[j].Index })
+
+	return segments, errors.Join(streamErr, run.finish(segments))
+}
+
+// windowStream reads stream and sends every window described by opts to
+// windows as soon as it is complete, applying the tail policy to a window
+// cut short by the end of the stream. Every window found is added to the
+// total of tracker.
+func windowStream(ctx context.Context, stream *PCMReader, opts SegmentOptions, windows chan<- streamWindow, tracker *progress.Tracker) error {
+	sampleRate := float64(stream.SampleRate)
+	channels := stream.Channels
+
+	hop := opts.HopDuration
+	if hop <= 0 {
+		hop = opts.SegmentDuration
+	}
+	segmentFrames := int64(math.Round(opts.SegmentDuration * sampleRate))
+	startFrame := func(i int) int64 {
+		return int64(math.Round(float64(i) * hop * sampleRate))
+	}
+
+	var buffer []int16
+	var bufferStart int64
+	next := 0
+
+	send := func(start int64, end int64, tail string) error {
+		from, to := int((start-bufferStart)*int64(channels)), int((end-bufferStart)*int64(channels))
+		pcm := PCM{
+			Samples:    append([]int16(nil), buffer[from:min(to, len(buffer))]...),
+			SampleRate: stream.SampleRate,
+			Channels:   channels,
+		}
+		if tail == TAIL_PAD {
+			pcm.Samples = append(pcm.Samples, make([]int16, to-from-len(pcm.Samples))...)
+		}
+
+		seconds := float64(start) / sampleRate
+		window := streamWindow{
+			boundary: SegmentBoundary{Index: next, Start: seconds, End: seconds + opts.SegmentDuration, Tail: tail},
+			pcm:      pcm,
+		}
+
+		tracker.AddTotal(1)
+
+		select {
+		case windows <- window:
+			next++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunk, err := stream.ReadFrames(stream.SampleRate)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		buffer = append(buffer, chunk.Samples...)
+
+		for {
+			bufferEnd := bufferStart + int64(len(buffer)/channels)
+			start := startFrame(next)
+			if start+segmentFrames > bufferEnd {
+				break
+			}
+			if err := send(start, start+segmentFrames, ""); err != nil {
+				return err
+			}
+		}
+
+		// Drop what no remaining window needs.
+		if drop := min(startFrame(next)-bufferStart, int64(len(buffer)/channels)); drop > 0 {
+			buffer = buffer[drop*int64(channels):]
+			bufferStart += drop
+		}
+	}
+
+	total := bufferStart + int64(len(buffer)/channels)
+	start := startFrame(next)
+	if start >= total || (next > 0 && startFrame(next-1)+segmentFrames >= total) {
+		return nil
+	}
+
+	tailDuration := float64(total-start) / sampleRate
+	tail := ""
+	if opts.MinTailDuration == 0 || tailDuration < opts.MinTailDuration {
+		switch opts.TailPolicy {
+		case TAIL_DROP:
+			return nil
+		case TAIL_PAD:
+			tail = TAIL_PAD
+		}
+	}
+
+	end := total
+	if tail == TAIL_PAD {
+		end = start + segmentFrames
+	}
+	return send(start, end, tail)
+}
+
+func encodeWindow(ctx context.Context, name string, outputDir string, cut CutOptions, window streamWindow) Segment {
+	boundary := window.boundary
+	segment := Segment{
+		Index:             boundary.Index,
+		SourcePath:        name,
+		Start:             boundary.Start,
+		RequestedDuration: boundary.Duration(),
+		Output:            cut.Output,
+		Tail:              boundary.Tail,
+	}
+
+	err := ctx.Err()
+	if err == nil {
+		segment, err = writeSegment(ctx, segment, outputDir, cut, window.pcm.Duration(), func(outputFilePath string) error {
+			return cut.Transcoder.Encode(ctx, window.pcm, outputFilePath, cut)
+		})
+	}
+
+	if err != nil {
+		segment.Err = fmt.Errorf("error processing segment %d: %w", boundary.Index, err)
+	}
+
+	return segment
+}
@@ -0,0 +1,236 @@
+package audiosegmenter
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"raga-recog-pipeline/pkg/progress"
+)
+
+// blockingReader never returns from Read until it is closed, like an idle
+// upload.
+type blockingReader struct {
+	closed chan struct{}
+}
+
+func (r blockingReader) Read(p []byte) (int, error) {
+	<-r.closed
+	return 0, io.EOF
+}
+
+// onlyReader hides every method but Read, as a pipe or request body would.
+type onlyReader struct {
+	io.Reader
+}
+
+func TestSegmentAudioStream_NativeWAV(t *testing.T) {
+	data, err := os.ReadFile(writeTestWAV(t, t.TempDir(), "tone.wav", 25, 8000, 24))
+	if err != nil {
+		t.Fatalf("Failed to read test WAV: %v", err)
+	}
+	outputDir := t.TempDir()
+
+	var emitted []int
+	segments, err := SegmentAudioStream(context.Background(), onlyReader{bytes.NewReader(data)}, "upload.wav", outputDir, SegmentOptions{
+		CutOptions:      CutOptions{Backend: BACKEND_NATIVE},
+		SegmentDuration: 10,
+	}, func(segment Segment) {
+		emitted = append(emitted, segment.Index)
+	})
+	if err != nil {
+		t.Fatalf("SegmentAudioStream() error = %v", err)
+	}
+
+	for _, err := range SegmentErrors(segments) {
+		t.Errorf("Error while segmenting stream: %v", err)
+	}
+
+	wantDurations := []float64{10, 10, 5}
+	if len(segments) != len(wantDurations) || len(emitted) != len(wantDurations) {
+		t.Fatalf("SegmentAudioStream() returned %d segments and emitted %d, want %d", len(segments), len(emitted), len(wantDurations))
+	}
+	for i, segment := range segments {
+		if segment.Index != i || segment.Start != float64(i*10) {
+			t.Errorf("Segment %d = %+v", i, segment)
+		}
+		if math.Abs(segment.ActualDuration-wantDurations[i]) > 1e-3 {
+			t.Errorf("Segment %d duration = %v, want %v", i, segment.ActualDuration, wantDurations[i])
+		}
+		if filepath.Ext(segment.OutputPath) != ".wav" {
+			t.Errorf("Segment %d written to %s", i, segment.OutputPath)
+		}
+	}
+
+	jsonPath, _ := ManifestPaths("upload.wav", outputDir)
+	manifest, err := ReadManifest(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+
+	sum := sha256.Sum256(data)
+	if manifest.SourcePath != "upload.wav" || manifest.SourceSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Manifest source = %s with hash %s", manifest.SourcePath, manifest.SourceSHA256)
+	}
+}
+
+func TestSegmentAudioStream_NativeMP3(t *testing.T) {
+	file, err := os.Open(filepath.Join(INPUT_DIR, NATIVE_SAMPLE_FILE_NAME))
+	if err != nil {
+		t.Fatalf("Failed to open sample: %v", err)
+	}
+	defer file.Close()
+
+	segments, err := SegmentAudioStream(context.Background(), onlyReader{file}, "stdin.mp3", t.TempDir(), SegmentOptions{
+		CutOptions:      CutOptions{Backend: BACKEND_NATIVE},
+		SegmentDuration: 10,
+		TailPolicy:      TAIL_DROP,
+	}, nil)
+	if err != nil {
+		t.Fatalf("SegmentAudioStream() error = %v", err)
+	}
+
+	// The sample is just over 30 seconds long and the short tail is dropped.
+	if len(segments) != 3 {
+		t.Fatalf("SegmentAudioStream() returned %d segments, want 3", len(segments))
+	}
+	for _, segment := range segments {
+		if segment.Err != nil || math.Abs(segment.ActualDuration-10) > 1e-3 {
+			t.Errorf("Segment %d = %+v", segment.Index, segment)
+		}
+	}
+}
+
+func TestSegmentAudioStream_Windows(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	transcoder.pcm["live.mp3"] = PCM{Samples: make([]int16, 65*100), SampleRate: 100, Channels: 1}
+	transcoder.cutDelay = 5 * time.Millisecond
+
+	var events []progress.Event
+	segments, err := SegmentAudioStream(context.Background(), bytes.NewReader(nil), "live.mp3", t.TempDir(), SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder},
+		SegmentDuration: 30,
+		HopDuration:     10,
+		Concurrency:     2,
+		TailPolicy:      TAIL_PAD,
+		Progress: func(event progress.Event) {
+			events = append(events, event)
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("SegmentAudioStream() error = %v", err)
+	}
+
+	var starts []float64
+	for _, segment := range segments {
+		starts = append(starts, segment.Start)
+		if segment.ActualDuration != 30 {
+			t.Errorf("Segment %d duration = %v, want 30", segment.Index, segment.ActualDuration)
+		}
+	}
+
+	if want := SegmentStarts(65, 30, 10); !reflect.DeepEqual(starts, want) {
+		t.Errorf("Stream segment starts = %v, want the same as SegmentStarts %v", starts, want)
+	}
+	if last := segments[len(segments)-1]; last.Tail != TAIL_PAD {
+		t.Errorf("Last segment tail = %q, want %q", last.Tail, TAIL_PAD)
+	}
+	if transcoder.maxActive > 2 {
+		t.Errorf("Encoded %d windows at once, want at most 2", transcoder.maxActive)
+	}
+
+	// The total grows as windows are read and never falls behind.
+	for _, event := range events {
+		if event.Completed > event.Total {
+			t.Errorf("Progress event %+v completed more than its total", event)
+		}
+	}
+	if last := events[len(events)-1]; last.Completed != len(segments) || last.Total != len(segments) {
+		t.Errorf("Last progress event = %+v, want %d segments", last, len(segments))
+	}
+}
+
+func TestSegmentAudioStream_Rejects(t *testing.T) {
+	_, err := SegmentAudioStream(context.Background(), bytes.NewReader(nil), "live.mp3", t.TempDir(), SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: newFakeTranscoder()},
+		SegmentDuration: 30,
+		TailPolicy:      TAIL_MERGE,
+	}, nil)
+	if err == nil {
+		t.Errorf("Expected the merge tail policy to be rejected for streams")
+	}
+
+	_, err = SegmentAudioStream(context.Background(), bytes.NewReader([]byte("not audio")), "live.ogg", t.TempDir(), SegmentOptions{
+		CutOptions:      CutOptions{Backend: BACKEND_NATIVE},
+		SegmentDuration: 30,
+	}, nil)
+	if err == nil {
+		t.Errorf("Expected an undecodable stream to be rejected")
+	}
+}
+
+func TestNewPCMReader_UnknownWAVSize(t *testing.T) {
+	var buf bytes.Buffer
+	samples := []int16{1, -1, 2, -2, 3, -3}
+	if err := WriteWAV(&buf, samples, 8000, 2, 16); err != nil {
+		t.Fatalf("WriteWAV() error = %v", err)
+	}
+
+	// Encoders writing to a pipe cannot fill in the sizes afterwards.
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data[4:8], wavUnknownSize)
+	binary.LittleEndian.PutUint32(data[40:44], wavUnknownSize)
+
+	reader, err := NewPCMReader(onlyReader{bytes.NewReader(data)}, "pipe.wav")
+	if err != nil {
+		t.Fatalf("NewPCMReader() error = %v", err)
+	}
+
+	pcm, err := reader.ReadFrames(100)
+	if err != nil || !reflect.DeepEqual(pcm.Samples, samples) {
+		t.Errorf("ReadFrames() = %v, %v, want %v", pcm.Samples, err, samples)
+	}
+	if _, err := reader.ReadFrames(100); err != io.EOF {
+		t.Errorf("ReadFrames() at the end error = %v, want io.EOF", err)
+	}
+}
+
+func TestFFmpegDecodeStream_CancelWithBlockingReader(t *testing.T) {
+	// A stand-in for ffmpeg that reads its stdin without ever writing a
+	// header, so that only cancelling can end the decode.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte("#!/bin/sh\nexec cat >/dev/null\n"), 0755); err != nil {
+		t.Fatalf("Failed to write fake ffmpeg: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r := blockingReader{closed: make(chan struct{})}
+	t.Cleanup(func() { close(r.closed) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := FFmpegTranscoder{}.DecodeStream(ctx, r, "upload.mp3")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected a cancelled decode to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("DecodeStream() still waiting on the blocked reader after cancellation")
+	}
+}
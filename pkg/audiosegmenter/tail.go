@@ -0,0 +1,72 @@
+package audiosegmenter
+
+import "fmt"
+
+// Policies for a final segment that is shorter than SegmentDuration.
+const (
+	// TAIL_KEEP cuts the short tail like any other segment.
+	TAIL_KEEP = "keep"
+	// TAIL_DROP leaves the short tail out.
+	TAIL_DROP = "drop"
+	// TAIL_MERGE extends the previous segment to the end of the source. A
+	// source with a single segment keeps it, as does a tail that starts
+	// after the previous segment ends, since a hop longer than the segments
+	// leaves audio between them that no segment is meant to cover.
+	TAIL_MERGE = "merge"
+	// TAIL_PAD pads the short tail with silence to SegmentDuration. Padding
+	// always re-encodes the tail.
+	TAIL_PAD = "pad"
+)
+
+func validateTailPolicy(policy string, minTailDuration float64) error {
+	switch policy {
+	case "", TAIL_KEEP, TAIL_DROP, TAIL_MERGE, TAIL_PAD:
+	default:
+		return fmt.Errorf("unknown tail policy %q", policy)
+	}
+
+	if minTailDuration < 0 {
+		return fmt.Errorf("minimum tail duration must not be negative, got %v", minTailDuration)
+	}
+
+	return nil
+}
+
+// ApplyTailPolicy applies policy to the last of boundaries when it covers
+// less than segmentDuration seconds of a source of the given duration. With
+// a positive minTailDuration only tails shorter than it are affected; longer
+// tails are kept. The affected boundary has its Tail set to the policy.
+func ApplyTailPolicy(boundaries []SegmentBoundary, duration float64, segmentDuration float64, policy string, minTailDuration float64) []SegmentBoundary {
+	if len(boundaries) == 0 || policy == "" || policy == TAIL_KEEP {
+		return boundaries
+	}
+
+	last := boundaries[len(boundaries)-1]
+	tailDuration := min(last.End, duration) - last.Start
+	if tailDuration >= segmentDuration || (minTailDuration > 0 && tailDuration >= minTailDuration) {
+		return boundaries
+	}
+
+	boundaries = append([]SegmentBoundary(nil), boundaries...)
+
+	switch policy {
+	case TAIL_DROP:
+		return boundaries[:len(boundaries)-1]
+
+	case TAIL_MERGE:
+		if len(boundaries) == 1 || last.Start > boundaries[len(boundaries)-2].End {
+			return boundaries
+		}
+		boundaries = boundaries[:len(boundaries)-1]
+		previous := &boundaries[len(boundaries)-1]
+		previous.End = max(previous.End, duration)
+		previous.Tail = TAIL_MERGE
+
+	case TAIL_PAD:
+		last.End = last.Start + segmentDuration
+		last.Tail = TAIL_PAD
+		boundaries[len(boundaries)-1] = last
+	}
+
+	return boundaries
+}
@@ -0,0 +1,199 @@
+package audiosegmenter
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type ApplyTailPolicyTest struct {
+	name            string
+	boundaries      []SegmentBoundary
+	duration        float64
+	policy          string
+	minTailDuration float64
+	want            []SegmentBoundary
+}
+
+func TestApplyTailPolicy(t *testing.T) {
+	windows := []SegmentBoundary{
+		{Index: 0, Start: 0, End: 30},
+		{Index: 1, Start: 30, End: 60},
+		{Index: 2, Start: 60, End: 90},
+	}
+
+	tests := []ApplyTailPolicyTest{
+		{
+			name:       "keep",
+			boundaries: windows,
+			duration:   62,
+			policy:     TAIL_KEEP,
+			want:       windows,
+		},
+		{
+			name:       "drop",
+			boundaries: windows,
+			duration:   62,
+			policy:     TAIL_DROP,
+			want:       windows[:2],
+		},
+		{
+			name:       "merge",
+			boundaries: windows,
+			duration:   62,
+			policy:     TAIL_MERGE,
+			want: []SegmentBoundary{
+				{Index: 0, Start: 0, End: 30},
+				{Index: 1, Start: 30, End: 62, Tail: TAIL_MERGE},
+			},
+		},
+		{
+			name:       "merge across a gap keeps the tail",
+			boundaries: []SegmentBoundary{{Index: 0, Start: 0, End: 10}, {Index: 1, Start: 40, End: 50}, {Index: 2, Start: 80, End: 90}},
+			duration:   85,
+			policy:     TAIL_MERGE,
+			want:       []SegmentBoundary{{Index: 0, Start: 0, End: 10}, {Index: 1, Start: 40, End: 50}, {Index: 2, Start: 80, End: 90}},
+		},
+		{
+			name:       "merge into a touching window",
+			boundaries: []SegmentBoundary{{Index: 0, Start: 0, End: 10}, {Index: 1, Start: 10, End: 20}},
+			duration:   14,
+			policy:     TAIL_MERGE,
+			want:       []SegmentBoundary{{Index: 0, Start: 0, End: 14, Tail: TAIL_MERGE}},
+		},
+		{
+			name:       "pad",
+			boundaries: []SegmentBoundary{{Index: 0, Start: 0, End: 30}, {Index: 1, Start: 30, End: 41.5}},
+			duration:   41.5,
+			policy:     TAIL_PAD,
+			want:       []SegmentBoundary{{Index: 0, Start: 0, End: 30}, {Index: 1, Start: 30, End: 60, Tail: TAIL_PAD}},
+		},
+		{
+			name:            "tail above threshold is kept",
+			boundaries:      windows,
+			duration:        85,
+			policy:          TAIL_DROP,
+			minTailDuration: 10,
+			want:            windows,
+		},
+		{
+			name:            "tail below threshold",
+			boundaries:      windows,
+			duration:        65,
+			policy:          TAIL_DROP,
+			minTailDuration: 10,
+			want:            windows[:2],
+		},
+		{
+			name:       "full last segment",
+			boundaries: windows,
+			duration:   90,
+			policy:     TAIL_DROP,
+			want:       windows,
+		},
+		{
+			name:       "merge a single segment",
+			boundaries: []SegmentBoundary{{Index: 0, Start: 0, End: 30}},
+			duration:   12,
+			policy:     TAIL_MERGE,
+			want:       []SegmentBoundary{{Index: 0, Start: 0, End: 30}},
+		},
+	}
+
+	for _, tailTest := range tests {
+		t.Run(tailTest.name, func(t *testing.T) {
+			got := ApplyTailPolicy(tailTest.boundaries, tailTest.duration, 30, tailTest.policy, tailTest.minTailDuration)
+			if !reflect.DeepEqual(got, tailTest.want) {
+				t.Errorf("ApplyTailPolicy() = %v, want %v", got, tailTest.want)
+			}
+		})
+	}
+
+	if windows[2].End != 90 || windows[1].Tail != "" {
+		t.Errorf("ApplyTailPolicy() modified its input: %v", windows)
+	}
+}
+
+func TestSegmentAudio_TailPolicy(t *testing.T) {
+	wantDurations := map[string][]float64{
+		TAIL_KEEP:  {30, 30, 5},
+		TAIL_DROP:  {30, 30},
+		TAIL_MERGE: {30, 35},
+		TAIL_PAD:   {30, 30, 30},
+	}
+
+	for policy, want := range wantDurations {
+		t.Run(policy, func(t *testing.T) {
+			transcoder := newFakeTranscoder()
+			inputFilePath := transcoder.addSource(t, "concert.mp3", 65)
+
+			segments, err := SegmentAudio(context.Background(), inputFilePath, t.TempDir(), SegmentOptions{
+				CutOptions:      CutOptions{Transcoder: transcoder},
+				SegmentDuration: 30,
+				TailPolicy:      policy,
+			})
+			if err != nil {
+				t.Fatalf("SegmentAudio() error = %v", err)
+			}
+
+			if len(segments) != len(want) {
+				t.Fatalf("SegmentAudio() returned %d segments, want %d", len(segments), len(want))
+			}
+			for i, segment := range segments {
+				if segment.ActualDuration != want[i] {
+					t.Errorf("Segment %d duration = %v, want %v", i, segment.ActualDuration, want[i])
+				}
+			}
+
+			last := segments[len(segments)-1]
+			switch policy {
+			case TAIL_MERGE, TAIL_PAD:
+				if last.Tail != policy {
+					t.Errorf("Last segment tail = %q, want %q", last.Tail, policy)
+				}
+			default:
+				if last.Tail != "" {
+					t.Errorf("Last segment tail = %q, want none", last.Tail)
+				}
+			}
+		})
+	}
+
+	_, err := SegmentAudio(context.Background(), "concert.mp3", t.TempDir(), SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: newFakeTranscoder()},
+		SegmentDuration: 30,
+		TailPolicy:      "trim",
+	})
+	if err == nil {
+		t.Errorf("Expected an unknown tail policy to be rejected")
+	}
+}
+
+func TestNativeTranscoderCut_Pad(t *testing.T) {
+	inputFilePath := writeTestWAV(t, t.TempDir(), "tone.wav", 25, 8000, 16)
+
+	segments, err := SegmentAudio(context.Background(), inputFilePath, t.TempDir(), SegmentOptions{
+		CutOptions:      CutOptions{Backend: BACKEND_NATIVE},
+		SegmentDuration: 10,
+		TailPolicy:      TAIL_PAD,
+	})
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	last := segments[len(segments)-1]
+	if last.Err != nil || math.Abs(last.ActualDuration-10) > 1e-3 {
+		t.Fatalf("Padded tail = %+v", last)
+	}
+
+	pcm, err := DecodeFile(last.OutputPath)
+	if err != nil {
+		t.Fatalf("Failed to decode padded tail: %v", err)
+	}
+	for _, sample := range pcm.Samples[6*pcm.SampleRate*pcm.Channels:] {
+		if sample != 0 {
+			t.Fatalf("Padding contains non-zero sample %d", sample)
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package audiosegmenter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	ffmpeg_go "github.com/u2takey/ffmpeg-go"
+)
+
+const (
+	// BACKEND_FFMPEG probes and cuts with the ffprobe and ffmpeg binaries.
+	BACKEND_FFMPEG = "ffmpeg"
+	// BACKEND_NATIVE decodes WAV and MP3 in Go and always writes WAV segments.
+	BACKEND_NATIVE = "native"
+)
+
+// Prober reads the duration, stream information and tags of audio files.
+type Prober interface {
+	ProbeDuration(ctx context.Context, inputFilePath string) (float64, error)
+	ProbeAudio(ctx context.Context, inputFilePath string) (AudioInfo, error)
+	ProbeMetadata(ctx context.Context, inputFilePath string) (RecordingMetadata, error)
+	// MeasureLoudness returns the integrated loudness of inputFilePath in
+	// LUFS, as specified by EBU R128.
+	MeasureLoudness(ctx context.Context, inputFilePath string) (float64, error)
+}
+
+// Transcoder is the backend that does the actual audio work for the
+// segmenter. FFmpegTranscoder is used when ffmpeg is installed and
+// NativeTranscoder otherwise.
+type Transcoder interface {
+	Prober
+	// Name identifies the backend in manifests.
+	Name() string
+	// ResolveOutput returns the spec the transcoder writes when asked for
+	// spec, or an error when it cannot produce it.
+	ResolveOutput(spec OutputSpec) (OutputSpec, error)
+	// Cut writes segmentDuration seconds of inputFilePath starting at
+	// segmentStart to outputFilePath. cut.Output has already been resolved.
+	Cut(ctx context.Context, inputFilePath string, outputFilePath string, segmentStart float64, segmentDuration float64, cut CutOptions) error
+	// DecodeMono decodes inputFilePath to a single channel at roughly
+	// sampleRate for analysis. The returned PCM holds the rate actually used.
+	DecodeMono(ctx context.Context, inputFilePath string, sampleRate int) (PCM, error)
+	// DecodeStream decodes audio read from r at its own rate and channel
+	// count. name identifies the stream and may hint at its format.
+	DecodeStream(ctx context.Context, r io.Reader, name string) (*PCMReader, error)
+	// Encode writes pcm to outputFilePath as described by cut.Output, which
+	// has already been resolved.
+	Encode(ctx context.Context, pcm PCM, outputFilePath string, cut CutOptions) error
+}
+
+var (
+	ffmpegAvailableOnce sync.Once
+	ffmpegAvailable     bool
+)
+
+// FFmpegAvailable reports whether both the ffmpeg and ffprobe binaries can be
+// found on the PATH.
+func FFmpegAvailable() bool {
+	ffmpegAvailableOnce.Do(func() {
+		_, ffmpegErr := exec.LookPath("ffmpeg")
+		_, ffprobeErr := exec.LookPath("ffprobe")
+		ffmpegAvailable = ffmpegErr == nil && ffprobeErr == nil
+	})
+	return ffmpegAvailable
+}
+
+// NewTranscoder returns the transcoder for backend. An empty backend picks
+// BACKEND_FFMPEG when the binaries are installed and BACKEND_NATIVE otherwise.
+func NewTranscoder(backend string) (Transcoder, error) {
+	if backend == "" {
+		backend = BACKEND_NATIVE
+		if FFmpegAvailable() {
+			backend = BACKEND_FFMPEG
+		}
+	}
+
+	switch backend {
+	case BACKEND_FFMPEG:
+		return FFmpegTranscoder{}, nil
+	case BACKEND_NATIVE:
+		return NativeTranscoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", backend)
+	}
+}
+
+// resolve fills in the transcoder selected by Backend, unless one was set
+// explicitly, and the output spec that transcoder will write.
+func (cut CutOptions) resolve() (CutOptions, error) {
+	if cut.Transcoder == nil {
+		transcoder, err := NewTranscoder(cut.Backend)
+		if err != nil {
+			return cut, err
+		}
+		cut.Transcoder = transcoder
+	}
+	cut.Backend = cut.Transcoder.Name()
+
+	if err := cut.Output.Validate(); err != nil {
+		return cut, err
+	}
+
+	if err := validateNaming(cut.NameTemplate, cut.Layout); err != nil {
+		return cut, err
+	}
+
+	if err := cut.Verify.Validate(); err != nil {
+		return cut, err
+	}
+
+	output, err := cut.Transcoder.ResolveOutput(cut.Output)
+	if err != nil {
+		return cut, err
+	}
+	cut.Output = output
+
+	return cut, nil
+}
+
+// FFmpegTranscoder runs the ffprobe and ffmpeg binaries through ffmpeg-go.
+type FFmpegTranscoder struct{}
+
+func (FFmpegTranscoder) Name() string {
+	return BACKEND_FFMPEG
+}
+
+func (FFmpegTranscoder) ProbeDuration(ctx context.Context, inputFilePath string) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	probeOutput, err := ffmpeg_go.Probe(inputFilePath)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return ParseDuration(probeOutput)
+}
+
+func (FFmpegTranscoder) ProbeAudio(ctx context.Context, inputFilePath string) (AudioInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return AudioInfo{}, err
+	}
+
+	probeOutput, err := ffmpeg_go.Probe(inputFilePath)
+
+	if err != nil {
+		return AudioInfo{}, err
+	}
+
+	return ParseAudioInfo(probeOutput)
+}
+
+func (FFmpegTranscoder) ProbeMetadata(ctx context.Context, inputFilePath string) (RecordingMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return RecordingMetadata{}, err
+	}
+
+	probeOutput, err := ffmpeg_go.Probe(inputFilePath)
+
+	if err != nil {
+		return RecordingMetadata{}, err
+	}
+
+	return ParseRecordingMetadata(probeOutput)
+}
+
+var integratedLoudnessPattern = regexp.MustCompile(`I:\s+(-?[0-9.]+) LUFS`)
+
+// MeasureLoudness runs the source through the ebur128 filter and reads the
+// integrated loudness from the summary it logs when done.
+func (FFmpegTranscoder) MeasureLoudness(ctx context.Context, inputFilePath string) (float64, error) {
+	log := bytes.NewBuffer(nil)
+	input := ffmpeg_go.Input(inputFilePath)
+	err := ffmpeg_go.OutputContext(ctx, []*ffmpeg_go.Stream{input}, "-", ffmpeg_go.KwArgs{
+		"af": "ebur128",
+		"vn": "",
+		"f":  "null",
+	}).WithErrorOutput(log).Run()
+
+	if err != nil {
+		return 0, err
+	}
+
+	matches := integratedLoudnessPattern.FindAllStringSubmatch(log.String(), -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("no integrated loudness in the ebur128 summary of %s", inputFilePath)
+	}
+
+	return strconv.ParseFloat(matches[len(matches)-1][1], 64)
+}
+
+func (FFmpegTranscoder) ResolveOutput(spec OutputSpec) (OutputSpec, error) {
+	return spec, nil
+}
+
+func (FFmpegTranscoder) Cut(ctx context.Context, inputFilePath string, outputFilePath string, segmentStart float64, segmentDuration float64, cut CutOptions) error {
+	inputArgs, outputArgs := cutArgs(inputFilePath, segmentStart, segmentDuration, cut)
+
+	input := ffmpeg_go.Input(inputFilePath, inputArgs)
+	return ffmpeg_go.OutputContext(ctx, []*ffmpeg_go.Stream{input}, outputFilePath, outputArgs).Run()
+}
+
+func (FFmpegTranscoder) DecodeMono(ctx context.Context, inputFilePath string, sampleRate int) (PCM, error) {
+	pcm := PCM{SampleRate: sampleRate, Channels: 1}
+
+	buf := bytes.NewBuffer(nil)
+	input := ffmpeg_go.Input(inputFilePath)
+	err := ffmpeg_go.OutputContext(ctx, []*ffmpeg_go.Stream{input}, "pipe:", ffmpeg_go.KwArgs{
+		"f":  "s16le",
+		"ac": "1",
+		"ar": strconv.Itoa(sampleRate),
+	}).WithOutput(buf).Run()
+
+	if err != nil {
+		return pcm, fmt.Errorf("failed to decode %s: %w", inputFilePath, err)
+	}
+
+	pcm.Samples = make([]int16, buf.Len()/2)
+	if err := binary.Read(buf, binary.LittleEndian, pcm.Samples); err != nil {
+		return pcm, fmt.Errorf("failed to read decoded samples: %w", err)
+	}
+
+	return pcm, nil
+}
+
+// DecodeStream has ffmpeg decode r to a WAV stream on its stdout, whose
+// header carries the sample rate and channel count of the source.
+func (FFmpegTranscoder) DecodeStream(ctx context.Context, r io.Reader, name string) (*PCMReader, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	pipeReader, pipeWriter := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		input := ffmpeg_go.Input("pipe:")
+		cmd := ffmpeg_go.OutputContext(ctx, []*ffmpeg_go.Stream{input}, "pipe:", ffmpeg_go.KwArgs{
+			"f":   "wav",
+			"c:a": "pcm_s16le",
+			"vn":  "",
+		}).WithOutput(pipeWriter).Compile()
+		err := runWithInput(cmd, r)
+		pipeWriter.CloseWithError(err)
+		done <- err
+	}()
+
+	// Cancelling stops ffmpeg if the caller gives up early; after a full
+	// read it has already exited and its error is the decoding result.
+	closeStream := func() error {
+		pipeReader.Close()
+		cancel()
+		return <-done
+	}
+
+	reader, err := newWAVPCMReader(pipeReader)
+	if err != nil {
+		closeStream()
+		return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+
+	return &PCMReader{
+		reader:     reader,
+		close:      closeStream,
+		SampleRate: reader.header.sampleRate,
+		Channels:   reader.header.channels,
+	}, nil
+}
+
+// runWithInput runs cmd with r copied to its stdin. Unlike setting
+// cmd.Stdin, the copy is not waited for once cmd has exited, so a reader that
+// blocks, such as an idle upload, cannot hold up a cancelled or failed run.
+func runWithInput(cmd *exec.Cmd, r io.Reader) error {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		io.Copy(stdin, r)
+		stdin.Close()
+	}()
+
+	return cmd.Wait()
+}
+
+func (FFmpegTranscoder) Encode(ctx context.Context, pcm PCM, outputFilePath string, cut CutOptions) error {
+	input := ffmpeg_go.Input("pipe:", ffmpeg_go.KwArgs{
+		"f":  "s16le",
+		"ar": strconv.Itoa(pcm.SampleRate),
+		"ac": strconv.Itoa(pcm.Channels),
+	})
+
+	outputArgs := cut.Output.encodeArgs()
+	outputArgs["y"] = ""
+
+	return ffmpeg_go.OutputContext(ctx, []*ffmpeg_go.Stream{input}, outputFilePath, outputArgs).
+		WithInput(bytes.NewReader(samplesToBytes(pcm.Samples))).
+		Run()
+}
+
+// cutArgs returns the ffmpeg input and output arguments for cutting
+// segmentDuration seconds starting at segmentStart from inputFilePath.
+func cutArgs(inputFilePath string, segmentStart float64, segmentDuration float64, cut CutOptions) (ffmpeg_go.KwArgs, ffmpeg_go.KwArgs) {
+	inputArgs := ffmpeg_go.KwArgs{}
+	outputArgs := ffmpeg_go.KwArgs{
+		"t": formatSeconds(segmentDuration),
+		"y": "",
+	}
+
+	if cut.Accurate {
+		// Seeking on the input while transcoding decodes from the preceding
+		// keyframe and discards samples up to the exact start.
+		inputArgs["ss"] = formatSeconds(segmentStart)
+	} else {
+		outputArgs["ss"] = formatSeconds(segmentStart)
+	}
+
+	if !cut.Accurate && !cut.Pad && cut.GainDB == 0 && cut.Output.IsPassthrough(inputFilePath) {
+		outputArgs["c"] = "copy"
+		return inputArgs, outputArgs
+	}
+
+	for key, value := range cut.Output.encodeArgs() {
+		outputArgs[key] = value
+	}
+	// Embedded cover art would otherwise be re-encoded into every segment.
+	outputArgs["vn"] = ""
+
+	var filters []string
+	if cut.GainDB != 0 {
+		filters = append(filters, "volume="+strconv.FormatFloat(cut.GainDB, 'f', -1, 64)+"dB")
+	}
+	if cut.Pad {
+		// apad pads indefinitely; the duration argument ends the output.
+		filters = append(filters, "apad")
+	}
+	if len(filters) > 0 {
+		outputArgs["af"] = strings.Join(filters, ",")
+	}
+
+	return inputArgs, outputArgs
+}
+
+// NativeTranscoder decodes WAV and MP3 in Go so that no external binaries
+// are needed. It always writes WAV, cannot resample and can only keep the
+// source channels or downmix to mono. Cuts are sample accurate whatever the
+// value of CutOptions.Accurate.
+type NativeTranscoder struct{}
+
+func (NativeTranscoder) Name() string {
+	return BACKEND_NATIVE
+}
+
+func (NativeTranscoder) ProbeDuration(ctx context.Context, inputFilePath string) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return ProbeDurationNative(inputFilePath)
+}
+
+func (NativeTranscoder) ProbeAudio(ctx context.Context, inputFilePath string) (AudioInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return AudioInfo{}, err
+	}
+
+	stream, err := OpenPCM(inputFilePath)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	defer stream.Close()
+
+	return stream.audioInfo(inputFilePath)
+}
+
+// ProbeMetadata reads the ID3v2 tag of MP3 files. Tags in WAV files are not
+// read by this backend.
+func (NativeTranscoder) ProbeMetadata(ctx context.Context, inputFilePath string) (RecordingMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return RecordingMetadata{}, err
+	}
+
+	file, err := os.Open(inputFilePath)
+	if err != nil {
+		return RecordingMetadata{}, fmt.Errorf("failed to open %s: %w", inputFilePath, err)
+	}
+	defer file.Close()
+
+	if !strings.EqualFold(filepath.Ext(inputFilePath), ".mp3") {
+		return RecordingMetadata{}, nil
+	}
+
+	tags, err := readID3Tags(bufio.NewReader(file))
+	if err != nil {
+		return RecordingMetadata{}, fmt.Errorf("failed to read tags of %s: %w", inputFilePath, err)
+	}
+
+	return NewRecordingMetadata(tags), nil
+}
+
+func (NativeTranscoder) MeasureLoudness(ctx context.Context, inputFilePath string) (float64, error) {
+	return measureLoudnessNative(ctx, inputFilePath)
+}
+
+func (NativeTranscoder) ResolveOutput(spec OutputSpec) (OutputSpec, error) {
+	if spec.Format == "" {
+		spec.Format = FORMAT_WAV
+	}
+
+	if spec.Format != FORMAT_WAV {
+		return spec, fmt.Errorf("the %s backend can only write %s, not %s", BACKEND_NATIVE, FORMAT_WAV, spec.Format)
+	}
+	if spec.SampleRate != 0 {
+		return spec, fmt.Errorf("the %s backend cannot resample", BACKEND_NATIVE)
+	}
+	if spec.Channels > 1 {
+		return spec, fmt.Errorf("the %s backend can only keep the source channels or downmix to mono", BACKEND_NATIVE)
+	}
+
+	return spec, nil
+}
+
+func (NativeTranscoder) Cut(ctx context.Context, inputFilePath string, outputFilePath string, segmentStart float64, segmentDuration float64, cut CutOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stream, err := OpenPCM(inputFilePath)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	pcm, err := stream.ReadRange(segmentStart, segmentDuration)
+	if err != nil {
+		return err
+	}
+
+	pcm = applyGain(pcm, cut.GainDB)
+
+	// A mono MP3 is decoded to stereo, so it is downmixed again to keep the
+	// source layout.
+	if cut.Output.Channels == 1 || stream.sourceChannels == 1 {
+		pcm = Downmix(pcm)
+	}
+
+	if cut.Pad {
+		frames := int(math.Round(segmentDuration * float64(pcm.SampleRate)))
+		if missing := frames*pcm.Channels - len(pcm.Samples); missing > 0 {
+			pcm.Samples = append(pcm.Samples, make([]int16, missing)...)
+		}
+	}
+
+	return writeWAVFile(outputFilePath, pcm, cut.Output.BitDepth)
+}
+
+func (NativeTranscoder) DecodeStream(ctx context.Context, r io.Reader, name string) (*PCMReader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewPCMReader(r, name)
+}
+
+func (NativeTranscoder) Encode(ctx context.Context, pcm PCM, outputFilePath string, cut CutOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	pcm = applyGain(pcm, cut.GainDB)
+
+	if cut.Output.Channels == 1 {
+		pcm = Downmix(pcm)
+	}
+
+	return writeWAVFile(outputFilePath, pcm, cut.Output.BitDepth)
+}
+
+func writeWAVFile(outputFilePath string, pcm PCM, bitDepth int) error {
+	file, err := os.Create(outputFilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if err := WriteWAV(writer, pcm.Samples, pcm.SampleRate, pcm.Channels, bitDepth); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// DecodeMono downmixes the source while decoding it and keeps every n-th
+// sample to get close to sampleRate, so that long recordings are never held
+// in memory at their full rate. Decimating without a filter aliases, but it
+// preserves the level of the signal, which is what the analysis needs.
+func (NativeTranscoder) DecodeMono(ctx context.Context, inputFilePath string, sampleRate int) (PCM, error) {
+	stream, err := OpenPCM(inputFilePath)
+	if err != nil {
+		return PCM{}, err
+	}
+	defer stream.Close()
+
+	factor := 1
+	if sampleRate > 0 && sampleRate < stream.SampleRate {
+		factor = int(math.Round(float64(stream.SampleRate) / float64(sampleRate)))
+	}
+
+	pcm := PCM{
+		Samples:    make([]int16, 0, stream.Frames()/int64(factor)+1),
+		SampleRate: stream.SampleRate / factor,
+		Channels:   1,
+	}
+
+	chunk := make([]byte, int(stream.frameSize())*factor*4096)
+	reader := bufio.NewReader(stream.reader)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return pcm, err
+		}
+
+		n, err := io.ReadFull(reader, chunk)
+		if n > 0 {
+			mono := Downmix(PCM{
+				Samples:    bytesToSamples(chunk[:n-n%int(stream.frameSize())]),
+				SampleRate: stream.SampleRate,
+				Channels:   stream.Channels,
+			})
+
+			for i := 0; i < len(mono.Samples); i += factor {
+				pcm.Samples = append(pcm.Samples, mono.Samples[i])
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return pcm, nil
+		}
+		if err != nil {
+			return pcm, fmt.Errorf("failed to decode %s: %w", inputFilePath, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package audiosegmenter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSegmentAudio_NativeBackend(t *testing.T) {
+	inputFilePath := writeTestWAV(t, t.TempDir(), "tone.wav", 25, 8000, 16)
+	outputDir := t.TempDir()
+
+	segments, err := SegmentAudio(context.Background(), inputFilePath, outputDir, SegmentOptions{
+		CutOptions:      CutOptions{Backend: BACKEND_NATIVE, Output: OutputSpec{Channels: 1}},
+		SegmentDuration: 10,
+		HopDuration:     5,
+	})
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	for _, err := range SegmentErrors(segments) {
+		t.Errorf("Error while segmenting audio: %v", err)
+	}
+
+	wantDurations := []float64{10, 10, 10, 10}
+	if len(segments) != len(wantDurations) {
+		t.Fatalf("SegmentAudio() returned %d segments, want %d", len(segments), len(wantDurations))
+	}
+
+	for i, segment := range segments {
+		if math.Abs(segment.ActualDuration-wantDurations[i]) > 1e-3 {
+			t.Errorf("Segment %d duration = %v, want %v", i, segment.ActualDuration, wantDurations[i])
+		}
+		if segment.Output.Format != FORMAT_WAV || filepath.Ext(segment.OutputPath) != ".wav" {
+			t.Errorf("Segment %d written as %s to %s, want WAV", i, segment.Output.Format, segment.OutputPath)
+		}
+
+		pcm, err := DecodeFile(segment.OutputPath)
+		if err != nil {
+			t.Fatalf("Failed to decode segment %d: %v", i, err)
+		}
+		if pcm.Channels != 1 {
+			t.Errorf("Segment %d has %d channels, want 1", i, pcm.Channels)
+		}
+	}
+}
+
+func TestSegmentAudio_NativeBackendMP3(t *testing.T) {
+	segments, err := SegmentAudio(context.Background(), filepath.Join(INPUT_DIR, NATIVE_SAMPLE_FILE_NAME), t.TempDir(), SegmentOptions{
+		CutOptions:      CutOptions{Backend: BACKEND_NATIVE},
+		SegmentDuration: 10,
+	})
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	for _, err := range SegmentErrors(segments) {
+		t.Errorf("Error while segmenting audio: %v", err)
+	}
+
+	// The sample is just over 30 seconds long, so the last segment is a short tail.
+	if len(segments) != 4 {
+		t.Fatalf("SegmentAudio() returned %d segments, want 4", len(segments))
+	}
+	if math.Abs(segments[0].ActualDuration-10) > 1e-3 {
+		t.Errorf("First segment duration = %v, want 10", segments[0].ActualDuration)
+	}
+}
+
+func TestCutOptionsResolve(t *testing.T) {
+	invalid := []CutOptions{
+		{Backend: BACKEND_NATIVE, Output: OutputSpec{Format: FORMAT_MP3}},
+		{Backend: BACKEND_NATIVE, Output: OutputSpec{Format: FORMAT_WAV, SampleRate: 44100}},
+		{Backend: BACKEND_NATIVE, Output: OutputSpec{Format: FORMAT_WAV, Channels: 2}},
+		{Backend: BACKEND_FFMPEG, Output: OutputSpec{Format: "ogg"}},
+		{Backend: "sox"},
+	}
+
+	for _, cut := range invalid {
+		if _, err := cut.resolve(); err == nil {
+			t.Errorf("Expected %+v to be rejected", cut)
+		}
+	}
+
+	cut, err := CutOptions{Backend: BACKEND_NATIVE}.resolve()
+	if err != nil {
+		t.Fatalf("Native backend with default output rejected: %v", err)
+	}
+	if _, ok := cut.Transcoder.(NativeTranscoder); !ok || cut.Output.Format != FORMAT_WAV {
+		t.Errorf("Native backend resolved to %T writing %q", cut.Transcoder, cut.Output.Format)
+	}
+
+	cut, err = CutOptions{Backend: BACKEND_NATIVE, Transcoder: newFakeTranscoder()}.resolve()
+	if err != nil {
+		t.Fatalf("Explicit transcoder rejected: %v", err)
+	}
+	if cut.Backend != "fake" {
+		t.Errorf("Explicit transcoder should override the backend, got %q", cut.Backend)
+	}
+}
+
+func TestNativeTranscoderDecodeMono(t *testing.T) {
+	inputFilePath := writeTestWAV(t, t.TempDir(), "tone.wav", 2, 8000, 16)
+
+	pcm, err := NativeTranscoder{}.DecodeMono(context.Background(), inputFilePath, 1000)
+	if err != nil {
+		t.Fatalf("DecodeMono() error = %v", err)
+	}
+
+	if pcm.Channels != 1 || pcm.SampleRate != 1000 {
+		t.Errorf("DecodeMono() returned %d channels at %d Hz, want 1 channel at 1000 Hz", pcm.Channels, pcm.SampleRate)
+	}
+	if pcm.Duration() != 2 {
+		t.Errorf("DecodeMono() duration = %v, want 2", pcm.Duration())
+	}
+}
+
+func TestNativeTranscoder_MonoMP3(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, channels := range []int{1, 2} {
+		inputFilePath := writeTestMP3(t, dir, fmt.Sprintf("tone_%d.mp3", channels), channels, 3)
+
+		info, err := NativeTranscoder{}.ProbeAudio(context.Background(), inputFilePath)
+		if err != nil || info.Channels != channels {
+			t.Errorf("ProbeAudio() = %+v, %v, want %d channels", info, err, channels)
+		}
+
+		// Cut segments and those of a stream keep the source layout.
+		opts := SegmentOptions{CutOptions: CutOptions{Backend: BACKEND_NATIVE}, SegmentDuration: 1}
+		cut, err := SegmentAudio(context.Background(), inputFilePath, filepath.Join(dir, "cut"), opts)
+		if err != nil {
+			t.Fatalf("SegmentAudio() error = %v", err)
+		}
+		file, err := os.Open(inputFilePath)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", inputFilePath, err)
+		}
+		streamed, err := SegmentAudioStream(context.Background(), file, filepath.Base(inputFilePath), filepath.Join(dir, "stream"), opts, nil)
+		file.Close()
+		if err != nil {
+			t.Fatalf("SegmentAudioStream() error = %v", err)
+		}
+
+		for _, segment := range append(cut, streamed...) {
+			pcm, err := DecodeFile(segment.OutputPath)
+			if err != nil {
+				t.Fatalf("Failed to decode %s: %v", segment.OutputPath, err)
+			}
+			if pcm.Channels != channels {
+				t.Errorf("%s has %d channels, want %d", segment.OutputPath, pcm.Channels, channels)
+			}
+		}
+	}
+}
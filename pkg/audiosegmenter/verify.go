@@ -0,0 +1,126 @@
+package audiosegmenter
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// VERIFY_FLAG leaves a segment that fails verification in place and
+	// reports it as failed.
+	VERIFY_FLAG = "flag"
+	// VERIFY_QUARANTINE also moves it into QUARANTINE_DIR of the output
+	// directory, so that loaders picking up every file never see it.
+	VERIFY_QUARANTINE = "quarantine"
+
+	// DEFAULT_VERIFY_TOLERANCE allows for stream copies snapping to the
+	// frame boundaries of the source codec.
+	DEFAULT_VERIFY_TOLERANCE = 0.25
+	QUARANTINE_DIR           = "quarantine"
+)
+
+// VerifyOptions enables checking every segment after it is written.
+type VerifyOptions struct {
+	// Tolerance is how many seconds the duration of a segment may differ
+	// from the request. Zero uses DEFAULT_VERIFY_TOLERANCE.
+	Tolerance float64 `json:"tolerance,omitempty"`
+	// Action is VERIFY_FLAG or VERIFY_QUARANTINE. Empty flags.
+	Action string `json:"action,omitempty"`
+}
+
+func (opts *VerifyOptions) Validate() error {
+	if opts == nil {
+		return nil
+	}
+	if opts.Tolerance < 0 {
+		return fmt.Errorf("verification tolerance must not be negative, got %v", opts.Tolerance)
+	}
+	switch opts.Action {
+	case "", VERIFY_FLAG, VERIFY_QUARANTINE:
+		return nil
+	default:
+		return fmt.Errorf("unknown verification action %q", opts.Action)
+	}
+}
+
+// VerificationError reports a segment whose output does not hold the audio
+// that was requested.
+type VerificationError struct {
+	OutputPath       string
+	ExpectedDuration float64
+	ActualDuration   float64
+	Size             int64
+	// Quarantined is set when the segment was moved to OutputPath in the
+	// quarantine directory.
+	Quarantined bool
+}
+
+func (e *VerificationError) Error() string {
+	message := fmt.Sprintf("segment %s lasts %vs, expected %vs", e.OutputPath, e.ActualDuration, e.ExpectedDuration)
+	if e.Size == 0 {
+		message = fmt.Sprintf("segment %s is empty", e.OutputPath)
+	}
+	if e.Quarantined {
+		message += " and was quarantined"
+	}
+	return message
+}
+
+// expectedDuration is how long a segment should turn out: the request,
+// unless the source ends first and the segment is not padded.
+func expectedDuration(segmentStart float64, segmentDuration float64, sourceDuration float64, pad bool) float64 {
+	if pad || sourceDuration <= 0 {
+		return segmentDuration
+	}
+	return max(0, min(segmentDuration, sourceDuration-segmentStart))
+}
+
+// verifySegment checks the size and probed duration of a written segment
+// against expected and flags or quarantines it as opts say.
+func verifySegment(segment Segment, outputDir string, expected float64, opts VerifyOptions) (Segment, error) {
+	tolerance := opts.Tolerance
+	if tolerance == 0 {
+		tolerance = DEFAULT_VERIFY_TOLERANCE
+	}
+
+	if segment.Size > 0 && math.Abs(segment.ActualDuration-expected) <= tolerance {
+		return segment, nil
+	}
+
+	verificationErr := &VerificationError{
+		OutputPath:       segment.OutputPath,
+		ExpectedDuration: expected,
+		ActualDuration:   segment.ActualDuration,
+		Size:             segment.Size,
+	}
+
+	if opts.Action != VERIFY_QUARANTINE {
+		return segment, verificationErr
+	}
+
+	rel, err := filepath.Rel(outputDir, segment.OutputPath)
+	if err != nil {
+		return segment, fmt.Errorf("failed to quarantine %s: %w", segment.OutputPath, err)
+	}
+	quarantinePath := filepath.Join(outputDir, QUARANTINE_DIR, rel)
+
+	if err := os.MkdirAll(filepath.Dir(quarantinePath), os.ModePerm); err != nil {
+		return segment, fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	if err := os.Rename(segment.OutputPath, quarantinePath); err != nil {
+		return segment, fmt.Errorf("failed to quarantine %s: %w", segment.OutputPath, err)
+	}
+	// A nested segment leaves its directory behind, which is removed if
+	// nothing else is in it.
+	if segmentDir := filepath.Dir(segment.OutputPath); segmentDir != filepath.Clean(outputDir) {
+		os.Remove(segmentDir)
+	}
+
+	segment.OutputPath = quarantinePath
+	verificationErr.OutputPath = quarantinePath
+	verificationErr.Quarantined = true
+
+	return segment, verificationErr
+}
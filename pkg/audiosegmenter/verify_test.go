@@ -0,0 +1,136 @@
+package audiosegmenter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type ExpectedDurationTest struct {
+	name           string
+	start          float64
+	duration       float64
+	sourceDuration float64
+	pad            bool
+	want           float64
+}
+
+func TestExpectedDuration(t *testing.T) {
+	tests := []ExpectedDurationTest{
+		{name: "inside the source", start: 30, duration: 30, sourceDuration: 95, want: 30},
+		{name: "tail", start: 90, duration: 30, sourceDuration: 95, want: 5},
+		{name: "padded tail", start: 90, duration: 30, sourceDuration: 95, pad: true, want: 30},
+		{name: "unknown source", start: 90, duration: 30, want: 30},
+	}
+
+	for _, durationTest := range tests {
+		t.Run(durationTest.name, func(t *testing.T) {
+			got := expectedDuration(durationTest.start, durationTest.duration, durationTest.sourceDuration, durationTest.pad)
+			if got != durationTest.want {
+				t.Errorf("expectedDuration() = %v, want %v", got, durationTest.want)
+			}
+		})
+	}
+}
+
+func TestSegmentAudio_Verify(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 95)
+	transcoder.truncate[30] = 12
+	transcoder.truncate[60] = -1
+	outputDir := t.TempDir()
+
+	segments, err := SegmentAudio(context.Background(), inputFilePath, outputDir, SegmentOptions{
+		CutOptions: CutOptions{
+			Transcoder: transcoder,
+			Verify:     &VerifyOptions{Tolerance: 0.1},
+		},
+		SegmentDuration: 30,
+	})
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	// The 5 second tail is what the source holds and passes.
+	for _, i := range []int{0, 3} {
+		if segments[i].Err != nil {
+			t.Errorf("Segment %d error = %v", i, segments[i].Err)
+		}
+	}
+
+	var short, empty *VerificationError
+	if !errors.As(segments[1].Err, &short) || short.ActualDuration != 12 || short.ExpectedDuration != 30 {
+		t.Errorf("Segment 1 error = %v, want a verification error", segments[1].Err)
+	}
+	if !errors.As(segments[2].Err, &empty) || empty.Size != 0 {
+		t.Errorf("Segment 2 error = %v, want a verification error for an empty file", segments[2].Err)
+	}
+
+	// Flagged segments stay where they are and are recorded as failed.
+	if _, err := os.Stat(segments[1].OutputPath); err != nil {
+		t.Errorf("Flagged segment was moved: %v", err)
+	}
+
+	jsonPath, _ := ManifestPaths(inputFilePath, outputDir)
+	manifest, err := ReadManifest(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.Segments[1].Error == "" || manifest.Segments[2].Error == "" || manifest.Segments[0].Error != "" {
+		t.Errorf("Manifest errors = %q, %q, %q", manifest.Segments[0].Error, manifest.Segments[1].Error, manifest.Segments[2].Error)
+	}
+}
+
+func TestSegmentAudio_VerifyQuarantine(t *testing.T) {
+	transcoder := newFakeTranscoder()
+	inputFilePath := transcoder.addSource(t, "concert.mp3", 60)
+	transcoder.truncate[30] = 1.5
+	outputDir := t.TempDir()
+	opts := SegmentOptions{
+		CutOptions: CutOptions{
+			Transcoder: transcoder,
+			Verify:     &VerifyOptions{Action: VERIFY_QUARANTINE},
+		},
+		SegmentDuration: 30,
+	}
+
+	segments, err := SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+
+	var verificationErr *VerificationError
+	if !errors.As(segments[1].Err, &verificationErr) || !verificationErr.Quarantined {
+		t.Fatalf("Segment 1 error = %v, want it quarantined", segments[1].Err)
+	}
+
+	wantPath := filepath.Join(outputDir, QUARANTINE_DIR, "concert_seg_1", "concert_seg_1.mp3")
+	if segments[1].OutputPath != wantPath {
+		t.Errorf("Quarantined segment at %s, want %s", segments[1].OutputPath, wantPath)
+	}
+	if _, err := os.Stat(wantPath); err != nil {
+		t.Errorf("Quarantined segment missing: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "concert_seg_1")); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty segment directory to be removed, got %v", err)
+	}
+
+	// A second run cuts the quarantined segment again and reuses the rest.
+	delete(transcoder.truncate, 30)
+	segments, err = SegmentAudio(context.Background(), inputFilePath, outputDir, opts)
+	if err != nil {
+		t.Fatalf("SegmentAudio() error = %v", err)
+	}
+	if segments[1].Err != nil || segments[1].Reused || !segments[0].Reused {
+		t.Errorf("Unexpected segments after fixing the cut: %+v", segments)
+	}
+
+	if _, err := SegmentAudio(context.Background(), inputFilePath, outputDir, SegmentOptions{
+		CutOptions:      CutOptions{Transcoder: transcoder, Verify: &VerifyOptions{Action: "delete"}},
+		SegmentDuration: 30,
+	}); err == nil {
+		t.Errorf("Expected an unknown verification action to be rejected")
+	}
+}
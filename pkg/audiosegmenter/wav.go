@@ -0,0 +1,291 @@
+package audiosegmenter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+
+	// wavUnknownSize is the placeholder size written by encoders, such as
+	// ffmpeg writing to a pipe, that cannot go back to fill in the real one.
+	wavUnknownSize = 0xFFFFFFFF
+)
+
+type wavHeader struct {
+	format        uint16
+	channels      int
+	sampleRate    int
+	bitsPerSample int
+	dataOffset    int64
+	// dataSize is -1 when the header does not give the size of the data,
+	// which then runs to the end of the stream.
+	dataSize int64
+}
+
+func (h wavHeader) bytesPerSample() int {
+	return h.bitsPerSample / 8
+}
+
+// readWAVHeader walks the RIFF chunks of a WAV file up to the start of its
+// data chunk and leaves r positioned at the first sample. Chunks are skipped
+// by reading them, so r does not need to be seekable.
+func readWAVHeader(r io.Reader) (wavHeader, error) {
+	var header wavHeader
+
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return header, fmt.Errorf("failed to read RIFF header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return header, errors.New("not a RIFF WAVE file")
+	}
+
+	offset := int64(len(riff))
+	foundFormat := false
+
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return header, fmt.Errorf("failed to find data chunk: %w", err)
+		}
+		offset += int64(len(chunk))
+
+		chunkID := string(chunk[0:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch chunkID {
+		case "fmt ":
+			fmtChunk := make([]byte, chunkSize)
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return header, fmt.Errorf("failed to read fmt chunk: %w", err)
+			}
+			if len(fmtChunk) < 16 {
+				return header, fmt.Errorf("fmt chunk is too short: %d bytes", len(fmtChunk))
+			}
+
+			header.format = binary.LittleEndian.Uint16(fmtChunk[0:2])
+			header.channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			header.sampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			header.bitsPerSample = int(binary.LittleEndian.Uint16(fmtChunk[14:16]))
+
+			if header.format == wavFormatExtensible && len(fmtChunk) >= 26 {
+				// The first two bytes of the sub-format GUID hold the actual format code.
+				header.format = binary.LittleEndian.Uint16(fmtChunk[24:26])
+			}
+			foundFormat = true
+
+		case "data":
+			if !foundFormat {
+				return header, errors.New("data chunk precedes fmt chunk")
+			}
+			header.dataOffset = offset
+			header.dataSize = chunkSize
+			if chunkSize == wavUnknownSize || chunkSize == 0 {
+				header.dataSize = -1
+			}
+			return header, header.validate()
+
+		default:
+			if _, err := io.CopyN(io.Discard, r, chunkSize); err != nil {
+				return header, fmt.Errorf("failed to skip %q chunk: %w", chunkID, err)
+			}
+		}
+
+		// Chunks are word aligned.
+		if chunkSize%2 == 1 && chunkID != "data" {
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+				return header, err
+			}
+			chunkSize++
+		}
+		offset += chunkSize
+	}
+}
+
+// codec returns the ffmpeg name of the sample format, e.g. pcm_s24le.
+func (h wavHeader) codec() string {
+	switch {
+	case h.format == wavFormatFloat:
+		return fmt.Sprintf("pcm_f%dle", h.bitsPerSample)
+	case h.bitsPerSample == 8:
+		return "pcm_u8"
+	default:
+		return fmt.Sprintf("pcm_s%dle", h.bitsPerSample)
+	}
+}
+
+func (h wavHeader) validate() error {
+	if h.channels <= 0 || h.sampleRate <= 0 {
+		return fmt.Errorf("invalid WAV layout: %d channels at %d Hz", h.channels, h.sampleRate)
+	}
+
+	switch {
+	case h.format == wavFormatPCM && (h.bitsPerSample == 8 || h.bitsPerSample == 16 || h.bitsPerSample == 24 || h.bitsPerSample == 32):
+	case h.format == wavFormatFloat && h.bitsPerSample == 32:
+	default:
+		return fmt.Errorf("unsupported WAV encoding: format %d with %d bits per sample", h.format, h.bitsPerSample)
+	}
+
+	return nil
+}
+
+// wavPCMReader presents the data chunk of a WAV file as interleaved 16-bit
+// little-endian samples, whatever its stored sample format. Offsets passed to
+// Seek are in that 16-bit representation; seeking needs a seekable source.
+type wavPCMReader struct {
+	source io.Reader
+	header wavHeader
+	pos    int64
+}
+
+func newWAVPCMReader(source io.Reader) (*wavPCMReader, error) {
+	header, err := readWAVHeader(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wavPCMReader{source: source, header: header}, nil
+}
+
+// Length returns the size of the data in its 16-bit representation, or -1
+// when the header does not give it.
+func (r *wavPCMReader) Length() int64 {
+	if r.header.dataSize < 0 {
+		return -1
+	}
+	return r.header.dataSize / int64(r.header.bytesPerSample()) * 2
+}
+
+func (r *wavPCMReader) Read(p []byte) (int, error) {
+	remaining := r.Length() - r.pos
+	if r.Length() < 0 {
+		remaining = math.MaxInt64
+	}
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	samples := min(int64(len(p)/2), remaining/2)
+	if samples == 0 {
+		return 0, nil
+	}
+
+	width := r.header.bytesPerSample()
+	raw := make([]byte, samples*int64(width))
+	n, err := io.ReadFull(r.source, raw)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+
+	read := n / width
+	for i := 0; i < read; i++ {
+		binary.LittleEndian.PutUint16(p[i*2:], uint16(r.decodeSample(raw[i*width:(i+1)*width])))
+	}
+
+	r.pos += int64(read * 2)
+	return read * 2, err
+}
+
+func (r *wavPCMReader) decodeSample(b []byte) int16 {
+	switch {
+	case r.header.format == wavFormatFloat:
+		return floatToInt16(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+	case r.header.bitsPerSample == 8:
+		return int16(int(b[0])-128) << 8
+	case r.header.bitsPerSample == 16:
+		return int16(binary.LittleEndian.Uint16(b))
+	case r.header.bitsPerSample == 24:
+		return int16(uint16(b[1]) | uint16(b[2])<<8)
+	default:
+		return int16(binary.LittleEndian.Uint32(b) >> 16)
+	}
+}
+
+func (r *wavPCMReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := r.source.(io.Seeker)
+	if !ok || r.Length() < 0 {
+		return 0, errors.New("cannot seek in a WAV stream")
+	}
+
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.Length() + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if pos < 0 {
+		return 0, fmt.Errorf("negative position %d", pos)
+	}
+	pos = min(pos, r.Length()) &^ 1
+
+	sourceOffset := r.header.dataOffset + pos/2*int64(r.header.bytesPerSample())
+	if _, err := seeker.Seek(sourceOffset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	r.pos = pos
+	return pos, nil
+}
+
+func floatToInt16(v float64) int16 {
+	v = math.Max(-1, math.Min(1, v))
+	return int16(math.Round(v * math.MaxInt16))
+}
+
+// WriteWAV writes interleaved 16-bit samples as a PCM WAV file with the given
+// bit depth. Depths above 16 bits are zero padded.
+func WriteWAV(w io.Writer, samples []int16, sampleRate int, channels int, bitDepth int) error {
+	if bitDepth == 0 {
+		bitDepth = 16
+	}
+	if bitDepth != 16 && bitDepth != 24 && bitDepth != 32 {
+		return fmt.Errorf("unsupported WAV bit depth %d", bitDepth)
+	}
+
+	bytesPerSample := bitDepth / 8
+	dataSize := len(samples) * bytesPerSample
+
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+dataSize))
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], wavFormatPCM)
+	binary.LittleEndian.PutUint16(header[22:24], uint16(channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(sampleRate*channels*bytesPerSample))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(channels*bytesPerSample))
+	binary.LittleEndian.PutUint16(header[34:36], uint16(bitDepth))
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(dataSize))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	data := make([]byte, dataSize)
+	for i, sample := range samples {
+		// Widen by shifting the 16-bit sample into the most significant bytes.
+		value := uint32(uint16(sample)) << (bitDepth - 16)
+		for b := 0; b < bytesPerSample; b++ {
+			data[i*bytesPerSample+b] = byte(value >> (8 * b))
+		}
+	}
+
+	_, err := w.Write(data)
+	return err
+}
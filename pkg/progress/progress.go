@@ -0,0 +1,115 @@
+// Package progress reports how far long running jobs, such as segmenting or
+// stem splitting a collection, have got.
+package progress
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is a snapshot of a job.
+type Event struct {
+	// Completed is the number of items finished, successfully or not.
+	Completed int `json:"completed"`
+	// Total is the number of items in the job. When it is not known up
+	// front, as when reading a stream, it counts the items found so far.
+	Total int `json:"total"`
+	// Current is the file most recently started or finished.
+	Current string `json:"current"`
+	// Fraction is how far Current has got, from 0 to 1, for jobs that can
+	// tell while an item runs. It is 0 when an item starts or finishes.
+	Fraction float64 `json:"fraction,omitempty"`
+	// Elapsed is the time since the job started.
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// Func receives the events of a job. It is never called concurrently by a
+// single Tracker and should return quickly, since the job waits for it.
+type Func func(Event)
+
+// Channel returns a Func that sends events to ch without ever blocking the
+// job. When ch is full the oldest event is thrown away to make room, so a
+// slow reader always finds the latest state; a buffer of one is enough. An
+// unbuffered ch only gets the events sent while its reader is waiting.
+func Channel(ch chan Event) Func {
+	return func(event Event) {
+		select {
+		case ch <- event:
+			return
+		default:
+		}
+
+		select {
+		case <-ch:
+		default:
+		}
+
+		// The Tracker is the only sender, so the slot just freed is still
+		// free, unless ch has no buffer at all.
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Tracker counts the items of a job and reports every change to a Func. A
+// Tracker with a nil Func does nothing, so jobs can always track progress
+// whether or not anyone listens.
+type Tracker struct {
+	mu        sync.Mutex
+	report    Func
+	started   time.Time
+	completed int
+	total     int
+	current   string
+}
+
+func NewTracker(report Func, total int) *Tracker {
+	return &Tracker{report: report, started: time.Now(), total: total}
+}
+
+// Start reports that work on current has begun.
+func (t *Tracker) Start(current string) {
+	t.update(current, 0, 0, 0)
+}
+
+// Advance reports that current, which has started but not finished, is
+// fraction of the way done.
+func (t *Tracker) Advance(current string, fraction float64) {
+	t.update(current, 0, 0, fraction)
+}
+
+// Done reports that an item of current has finished.
+func (t *Tracker) Done(current string) {
+	t.update(current, 1, 0, 0)
+}
+
+// AddTotal grows the job by n items, as when a stream turns out to hold
+// another segment.
+func (t *Tracker) AddTotal(n int) {
+	t.update("", 0, n, 0)
+}
+
+func (t *Tracker) update(current string, completed int, total int, fraction float64) {
+	if t == nil || t.report == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if current != "" {
+		t.current = current
+	}
+	t.completed += completed
+	t.total += total
+
+	t.report(Event{
+		Completed: t.completed,
+		Total:     t.total,
+		Current:   t.current,
+		Fraction:  fraction,
+		Elapsed:   time.Since(t.started),
+	})
+}
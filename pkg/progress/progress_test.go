@@ -0,0 +1,128 @@
+package progress
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	var events []Event
+	tracker := NewTracker(func(event Event) {
+		events = append(events, event)
+	}, 2)
+
+	tracker.Start("todi.mp3")
+	tracker.Advance("todi.mp3", 0.5)
+	tracker.Done("todi.mp3")
+	tracker.AddTotal(1)
+	tracker.Done("kapi.mp3")
+
+	want := []Event{
+		{Completed: 0, Total: 2, Current: "todi.mp3"},
+		{Completed: 0, Total: 2, Current: "todi.mp3", Fraction: 0.5},
+		{Completed: 1, Total: 2, Current: "todi.mp3"},
+		{Completed: 1, Total: 3, Current: "todi.mp3"},
+		{Completed: 2, Total: 3, Current: "kapi.mp3"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Got %d events, want %d", len(events), len(want))
+	}
+	for i, event := range events {
+		elapsed := event.Elapsed
+		event.Elapsed = 0
+		if event != want[i] {
+			t.Errorf("Event %d = %+v, want %+v", i, event, want[i])
+		}
+		if i > 0 && elapsed < events[i-1].Elapsed {
+			t.Errorf("Event %d went back in time: %v", i, elapsed)
+		}
+	}
+}
+
+func TestTracker_Concurrent(t *testing.T) {
+	completed := 0
+	tracker := NewTracker(func(event Event) {
+		// The tracker serialises calls, so this needs no lock.
+		completed = event.Completed
+	}, 100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracker.Done("concert.mp3")
+		}()
+	}
+	wg.Wait()
+
+	if completed != 100 {
+		t.Errorf("Last event reported %d completed, want 100", completed)
+	}
+}
+
+func TestTracker_WithoutListener(t *testing.T) {
+	var tracker *Tracker
+	tracker.Start("concert.mp3")
+	tracker.Done("concert.mp3")
+
+	NewTracker(nil, 1).Done("concert.mp3")
+}
+
+func TestChannel(t *testing.T) {
+	ch := make(chan Event, 1)
+	tracker := NewTracker(Channel(ch), 3)
+
+	// A full channel swaps its stale event for the new one instead of
+	// blocking the job.
+	tracker.Done("a.mp3")
+	tracker.Done("b.mp3")
+
+	if event := <-ch; event.Completed != 2 || event.Current != "b.mp3" {
+		t.Errorf("Buffered event = %+v", event)
+	}
+
+	tracker.Done("c.mp3")
+	if event := <-ch; event.Completed != 3 || event.Current != "c.mp3" {
+		t.Errorf("Latest event = %+v", event)
+	}
+}
+
+func TestChannel_KeepsFinalEvent(t *testing.T) {
+	ch := make(chan Event, 1)
+	tracker := NewTracker(Channel(ch), 5)
+
+	for _, name := range []string{"a.mp3", "b.mp3", "c.mp3", "d.mp3", "e.mp3"} {
+		tracker.Start(name)
+		tracker.Done(name)
+	}
+
+	var last Event
+	for len(ch) > 0 {
+		last = <-ch
+	}
+	if last.Completed != last.Total || last.Current != "e.mp3" {
+		t.Errorf("Last event = %+v, want the finished job", last)
+	}
+}
+
+func TestChannel_Unbuffered(t *testing.T) {
+	ch := make(chan Event)
+	tracker := NewTracker(Channel(ch), 2)
+
+	// Without a waiting reader the events are dropped rather than holding
+	// up the job.
+	done := make(chan struct{})
+	go func() {
+		tracker.Done("a.mp3")
+		tracker.Done("b.mp3")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Job blocked on an unbuffered channel without a reader")
+	}
+}
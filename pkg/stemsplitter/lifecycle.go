@@ -0,0 +1,98 @@
+package stemsplitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+const (
+	// CONTAINER_LABEL marks the containers RunStemSplitting creates, so that
+	// RemoveOrphanedContainers can find them. Its value is the input file.
+	CONTAINER_LABEL = "raga-recog-pipeline.stemsplitter"
+
+	// CONTAINER_STOP_SECONDS is how long demucs gets to exit after SIGTERM
+	// before it is killed.
+	CONTAINER_STOP_SECONDS = 10
+	// CONTAINER_CLEANUP_TIMEOUT bounds stopping and removing a container,
+	// which happens even after the context of the job is done.
+	CONTAINER_CLEANUP_TIMEOUT = 30 * time.Second
+	// LOCAL_WAIT_DELAY is how long LocalSplitter waits for the output of a
+	// killed demucs, whose workers may hold on to it, before giving up.
+	LOCAL_WAIT_DELAY = 5 * time.Second
+)
+
+// jobContext applies the timeout of opts to ctx.
+func jobContext(ctx context.Context, opts SeparationOptions) (context.Context, context.CancelFunc) {
+	if opts.Timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, opts.Timeout)
+}
+
+// jobError explains err when it was caused by the job context ending: the
+// timeout of opts rather than the caller cancelling parent.
+func jobError(parent context.Context, job context.Context, opts SeparationOptions, err error) error {
+	if job.Err() == nil || parent.Err() != nil {
+		return err
+	}
+	if !errors.Is(err, job.Err()) {
+		err = fmt.Errorf("%w: %v", job.Err(), err)
+	}
+	return fmt.Errorf("demucs did not finish within %v: %w", opts.Timeout, err)
+}
+
+// removeContainer stops the container id, if it is still running, and
+// removes it. It uses a context of its own so that it also cleans up after
+// jobs that were cancelled or timed out.
+func removeContainer(cli *client.Client, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), CONTAINER_CLEANUP_TIMEOUT)
+	defer cancel()
+
+	timeout := CONTAINER_STOP_SECONDS
+	if err := cli.ContainerStop(ctx, id, container.StopOptions{Timeout: &timeout}); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to stop container %s: %w", id, err)
+	}
+	if err := cli.ContainerRemove(ctx, id, container.RemoveOptions{Force: true}); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to remove container %s: %w", id, err)
+	}
+	return nil
+}
+
+// RemoveOrphanedContainers removes the containers labelled CONTAINER_LABEL
+// that were created more than olderThan ago, such as those left behind when
+// a process splitting stems was killed. olderThan should exceed the timeout
+// of any job still running, since their containers are removed as well. It
+// returns the IDs of the containers removed.
+func RemoveOrphanedContainers(ctx context.Context, cli *client.Client, olderThan time.Duration) ([]string, error) {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", CONTAINER_LABEL)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+
+	var removed []string
+	var errs []error
+	for _, c := range containers {
+		if time.Unix(c.Created, 0).After(cutoff) {
+			continue
+		}
+		if err := removeContainer(cli, c.ID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, c.ID)
+	}
+
+	return removed, errors.Join(errs...)
+}
@@ -0,0 +1,138 @@
+package stemsplitter
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// LOG_TAIL_LINES is how many trailing lines of demucs output an
+	// ExitError carries.
+	LOG_TAIL_LINES = 20
+	// LOG_TAIL_BYTES bounds the output kept for it, so that the progress
+	// bars demucs prints do not pile up in memory.
+	LOG_TAIL_BYTES = 64 * 1024
+	// PROGRESS_LINE_BYTES is how much of each line progressWriter looks at.
+	// The percentage comes first, so the rest of the bar can be ignored.
+	PROGRESS_LINE_BYTES = 64
+)
+
+// progressPattern matches the start of a tqdm progress bar as demucs prints
+// it, such as " 45%|████▌     | 105.3/234.0 [00:12<00:15, 8.50seconds/s]".
+var progressPattern = regexp.MustCompile(`^\s*(\d{1,3})%\|`)
+
+// ExitError reports a separation whose process, or container, exited with
+// a non-zero status, as demucs does when it runs out of memory or cannot
+// decode its input.
+type ExitError struct {
+	// Name is the command or container that failed.
+	Name     string
+	ExitCode int64
+	// Logs are the last LOG_TAIL_LINES lines of its stdout and stderr.
+	Logs string
+}
+
+func (e *ExitError) Error() string {
+	if e.Logs == "" {
+		return fmt.Sprintf("%s exited with code %d", e.Name, e.ExitCode)
+	}
+	return fmt.Sprintf("%s exited with code %d: %s", e.Name, e.ExitCode, e.Logs)
+}
+
+// tailWriter keeps the last max bytes written to it. It only drops older
+// output once it holds twice as much, so that writes stay cheap.
+type tailWriter struct {
+	max int
+	buf []byte
+}
+
+func newTailWriter() *tailWriter {
+	return &tailWriter{max: LOG_TAIL_BYTES}
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if over := len(w.buf) - w.max; over > w.max {
+		w.buf = w.buf[:copy(w.buf, w.buf[over:])]
+	}
+	return len(p), nil
+}
+
+// Tail returns the last LOG_TAIL_LINES lines written.
+func (w *tailWriter) Tail() string {
+	buf := w.buf
+	if len(buf) > w.max {
+		buf = buf[len(buf)-w.max:]
+	}
+	return lastLines(string(buf), LOG_TAIL_LINES)
+}
+
+// progressWriter reports the percentages of the progress bars written to
+// it. demucs redraws a bar by starting the line over with a carriage return,
+// so every line ending in one is a new state of the bar.
+type progressWriter struct {
+	report  func(fraction float64)
+	line    []byte
+	percent int
+}
+
+func newProgressWriter(report func(fraction float64)) *progressWriter {
+	return &progressWriter{report: report, percent: -1}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != '\r' && b != '\n' {
+			if len(w.line) < PROGRESS_LINE_BYTES {
+				w.line = append(w.line, b)
+			}
+			continue
+		}
+
+		if match := progressPattern.FindSubmatch(w.line); match != nil {
+			percent, _ := strconv.Atoi(string(match[1]))
+			if percent <= 100 && percent != w.percent {
+				w.percent = percent
+				w.report(float64(percent) / 100)
+			}
+		}
+		w.line = w.line[:0]
+	}
+	return len(p), nil
+}
+
+// logWriter returns where the output of a separation goes: the tail, its
+// progress bars to opts.Progress and everything to opts.Logs, when those
+// are set. opts.Logs comes last, since the copy stops at the first writer
+// that fails.
+func logWriter(tail *tailWriter, opts SeparationOptions) io.Writer {
+	writers := []io.Writer{tail}
+	if opts.Progress != nil {
+		writers = append(writers, newProgressWriter(opts.Progress))
+	}
+	if opts.Logs != nil {
+		writers = append(writers, opts.Logs)
+	}
+	if len(writers) == 1 {
+		return tail
+	}
+	return io.MultiWriter(writers...)
+}
+
+// lastLines returns at most n trailing lines of output. demucs redraws its
+// progress bar with carriage returns, so only the last state of each line
+// is kept.
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimRight(output, "\r\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		lines[i] = line[strings.LastIndexByte(line, '\r')+1:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,100 @@
+package stemsplitter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type LastLinesTest struct {
+	name   string
+	output string
+	n      int
+	want   string
+}
+
+func TestLastLines(t *testing.T) {
+	tests := []LastLinesTest{
+		{name: "short", output: "loading model\n", n: 5, want: "loading model"},
+		{name: "trailing lines", output: "a\nb\nc\nd\n", n: 2, want: "c\nd"},
+		{name: "progress bar", output: "separating\n 10%|#\r 50%|#####\r100%|##########\r\nKilled\n", n: 5, want: "separating\n100%|##########\nKilled"},
+	}
+
+	for _, linesTest := range tests {
+		t.Run(linesTest.name, func(t *testing.T) {
+			if got := lastLines(linesTest.output, linesTest.n); got != linesTest.want {
+				t.Errorf("lastLines() = %q, want %q", got, linesTest.want)
+			}
+		})
+	}
+}
+
+func TestTailWriter(t *testing.T) {
+	tail := newTailWriter()
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(tail, "line %d\n", i)
+	}
+
+	if len(tail.buf) > 2*LOG_TAIL_BYTES {
+		t.Errorf("Tail holds %d bytes, want at most %d", len(tail.buf), 2*LOG_TAIL_BYTES)
+	}
+	lines := strings.Split(tail.Tail(), "\n")
+	if len(lines) != LOG_TAIL_LINES || lines[len(lines)-1] != "line 9999" {
+		t.Errorf("Tail() = %q", lines)
+	}
+}
+
+type ProgressWriterTest struct {
+	name   string
+	writes []string
+	want   []float64
+}
+
+func TestProgressWriter(t *testing.T) {
+	tests := []ProgressWriterTest{
+		{
+			name:   "bar redrawn in one write",
+			writes: []string{"\r  0%|          | 0.0/234.0\r 45%|####5     | 105.3/234.0\r100%|##########| 234.0/234.0\n"},
+			want:   []float64{0, 0.45, 1},
+		},
+		{
+			name:   "bar split across writes",
+			writes: []string{"\r 4", "5%|####5  ", "   | 105.3/234.0", "\r"},
+			want:   []float64{0.45},
+		},
+		{
+			name:   "unchanged percentage",
+			writes: []string{" 45%|#### | 105.3/234.0\r 45%|#### | 105.9/234.0\r"},
+			want:   []float64{0.45},
+		},
+		{
+			name:   "bar of the next model",
+			writes: []string{"100%|##########| 234.0/234.0\n  0%|          | 0.0/234.0\r"},
+			want:   []float64{1, 0},
+		},
+		{
+			name:   "other output",
+			writes: []string{"Separating track concert.mp3\nSelected model is a bag of 4 models. 100% sure\n"},
+		},
+		{
+			name:   "unfinished line",
+			writes: []string{" 45%|####5     | 105.3/234.0"},
+		},
+	}
+
+	for _, writerTest := range tests {
+		t.Run(writerTest.name, func(t *testing.T) {
+			var got []float64
+			w := newProgressWriter(func(fraction float64) {
+				got = append(got, fraction)
+			})
+			for _, write := range writerTest.writes {
+				fmt.Fprint(w, write)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(writerTest.want) {
+				t.Errorf("Reported %v, want %v", got, writerTest.want)
+			}
+		})
+	}
+}
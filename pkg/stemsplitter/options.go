@@ -0,0 +1,164 @@
+package stemsplitter
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_MODEL = "htdemucs"
+
+	FORMAT_MP3 = "mp3"
+	FORMAT_WAV = "wav"
+
+	DEVICE_CPU  = "cpu"
+	DEVICE_CUDA = "cuda"
+	DEVICE_MPS  = "mps"
+)
+
+// safeName matches model and stem names, which end up on the command line of
+// the container entrypoint.
+var safeName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// SeparationOptions configure a single demucs run. The zero value separates
+// with DEFAULT_MODEL on the CPU into four MP3 stems.
+type SeparationOptions struct {
+	// Model is the name of the pretrained demucs model. Empty uses
+	// DEFAULT_MODEL.
+	Model string `json:"model,omitempty"`
+	// TwoStems, such as "vocals", separates that stem from everything else,
+	// which is written as no_<stem>.
+	TwoStems string `json:"two_stems,omitempty"`
+	// Format is FORMAT_MP3 or FORMAT_WAV. Empty writes MP3.
+	Format string `json:"format,omitempty"`
+	// Device is DEVICE_CPU, DEVICE_CUDA or DEVICE_MPS. Empty runs on the CPU.
+	Device string `json:"device,omitempty"`
+	// Shifts averages the predictions of this many random time shifts of the
+	// input. Zero uses the demucs default.
+	Shifts int `json:"shifts,omitempty"`
+	// Overlap is the fraction by which the windows the model is applied to
+	// overlap. Zero uses the demucs default of 0.25.
+	Overlap float64 `json:"overlap,omitempty"`
+	// SegmentLength is the length in seconds of those windows. Zero uses the
+	// default of the model; transformer models allow at most 7 seconds.
+	SegmentLength int `json:"segment_length,omitempty"`
+	// Timeout ends the job, and the container or process running it, if
+	// demucs has not finished by then. Zero waits for as long as the context
+	// of the job allows.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Logs, when set, receives the complete stdout and stderr of demucs as
+	// it runs.
+	Logs io.Writer `json:"-"`
+	// Progress, when set, is called with the fraction of the input separated
+	// each time the progress bar demucs prints moves. demucs draws a bar for
+	// every model of a bag and every shift, so the fraction starts over for
+	// each of them.
+	Progress func(fraction float64) `json:"-"`
+}
+
+func (opts SeparationOptions) Validate() error {
+	if opts.Model != "" && !safeName.MatchString(opts.Model) {
+		return fmt.Errorf("invalid model name %q", opts.Model)
+	}
+	if opts.TwoStems != "" && !safeName.MatchString(opts.TwoStems) {
+		return fmt.Errorf("invalid stem name %q", opts.TwoStems)
+	}
+
+	switch opts.Format {
+	case "", FORMAT_MP3, FORMAT_WAV:
+	default:
+		return fmt.Errorf("unsupported stem format %q", opts.Format)
+	}
+
+	switch opts.Device {
+	case "", DEVICE_CPU, DEVICE_CUDA, DEVICE_MPS:
+	default:
+		return fmt.Errorf("unsupported device %q", opts.Device)
+	}
+
+	if opts.Shifts < 0 {
+		return fmt.Errorf("shifts must not be negative, got %d", opts.Shifts)
+	}
+	if !(opts.Overlap >= 0 && opts.Overlap < 1) {
+		return fmt.Errorf("overlap must be at least 0 and below 1, got %v", opts.Overlap)
+	}
+	if opts.SegmentLength < 0 {
+		return fmt.Errorf("segment length must not be negative, got %d", opts.SegmentLength)
+	}
+	if opts.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative, got %v", opts.Timeout)
+	}
+
+	return nil
+}
+
+// model is the model demucs runs, which also names the directory it writes
+// the stems to.
+func (opts SeparationOptions) model() string {
+	if opts.Model == "" {
+		return DEFAULT_MODEL
+	}
+	return opts.Model
+}
+
+func (opts SeparationOptions) device() string {
+	if opts.Device == "" {
+		return DEVICE_CPU
+	}
+	return opts.Device
+}
+
+// Ext is the extension of the stems written with opts, without the dot.
+func (opts SeparationOptions) Ext() string {
+	if opts.Format == "" {
+		return FORMAT_MP3
+	}
+	return opts.Format
+}
+
+// args are the demucs arguments for opts, without the output directory and
+// the input.
+func (opts SeparationOptions) args() []string {
+	args := []string{"-n", opts.model(), "-d", opts.device()}
+	if opts.Ext() == FORMAT_MP3 {
+		args = append(args, "--mp3")
+	}
+	if opts.TwoStems != "" {
+		args = append(args, "--two-stems", opts.TwoStems)
+	}
+	if opts.Shifts > 0 {
+		args = append(args, "--shifts", strconv.Itoa(opts.Shifts))
+	}
+	if opts.Overlap > 0 {
+		args = append(args, "--overlap", strconv.FormatFloat(opts.Overlap, 'f', -1, 64))
+	}
+	if opts.SegmentLength > 0 {
+		args = append(args, "--segment", strconv.Itoa(opts.SegmentLength))
+	}
+	return args
+}
+
+// env are the variables docker/demucs_entrypoint.sh reads opts from.
+func (opts SeparationOptions) env() []string {
+	env := []string{
+		"MODEL=" + opts.model(),
+		"DEVICE=" + opts.device(),
+		fmt.Sprintf("MP3OUTPUT=%t", opts.Ext() == FORMAT_MP3),
+	}
+	if opts.TwoStems != "" {
+		env = append(env, "SPLITTRACK="+opts.TwoStems)
+	}
+	if opts.Shifts > 0 {
+		env = append(env, "SHIFTS="+strconv.Itoa(opts.Shifts))
+	}
+	if opts.Overlap > 0 {
+		env = append(env, "OVERLAP="+strconv.FormatFloat(opts.Overlap, 'f', -1, 64))
+	}
+	if opts.SegmentLength > 0 {
+		env = append(env, "SEGMENT="+strconv.Itoa(opts.SegmentLength))
+	}
+	return env
+}
@@ -0,0 +1,66 @@
+package stemsplitter
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+type SeparationOptionsTest struct {
+	name     string
+	opts     SeparationOptions
+	wantErr  bool
+	wantArgs string
+	wantEnv  string
+}
+
+func TestSeparationOptions(t *testing.T) {
+	tests := []SeparationOptionsTest{
+		{
+			name:     "defaults",
+			wantArgs: "-n htdemucs -d cpu --mp3",
+			wantEnv:  "MODEL=htdemucs DEVICE=cpu MP3OUTPUT=true",
+		},
+		{
+			name:     "vocals as wav on the GPU",
+			opts:     SeparationOptions{Model: "htdemucs_ft", TwoStems: "vocals", Format: FORMAT_WAV, Device: DEVICE_CUDA},
+			wantArgs: "-n htdemucs_ft -d cuda --two-stems vocals",
+			wantEnv:  "MODEL=htdemucs_ft DEVICE=cuda MP3OUTPUT=false SPLITTRACK=vocals",
+		},
+		{
+			name:     "quality",
+			opts:     SeparationOptions{Shifts: 5, Overlap: 0.5, SegmentLength: 7},
+			wantArgs: "-n htdemucs -d cpu --mp3 --shifts 5 --overlap 0.5 --segment 7",
+			wantEnv:  "MODEL=htdemucs DEVICE=cpu MP3OUTPUT=true SHIFTS=5 OVERLAP=0.5 SEGMENT=7",
+		},
+		{name: "model with spaces", opts: SeparationOptions{Model: "htdemucs; rm -rf /"}, wantErr: true},
+		{name: "stem with spaces", opts: SeparationOptions{TwoStems: "lead vocals"}, wantErr: true},
+		{name: "flac", opts: SeparationOptions{Format: "flac"}, wantErr: true},
+		{name: "tpu", opts: SeparationOptions{Device: "tpu"}, wantErr: true},
+		{name: "negative shifts", opts: SeparationOptions{Shifts: -1}, wantErr: true},
+		{name: "full overlap", opts: SeparationOptions{Overlap: 1}, wantErr: true},
+		{name: "NaN overlap", opts: SeparationOptions{Overlap: math.NaN()}, wantErr: true},
+		{name: "negative segment", opts: SeparationOptions{SegmentLength: -7}, wantErr: true},
+		{name: "negative timeout", opts: SeparationOptions{Timeout: -time.Second}, wantErr: true},
+	}
+
+	for _, optsTest := range tests {
+		t.Run(optsTest.name, func(t *testing.T) {
+			err := optsTest.opts.Validate()
+			if (err != nil) != optsTest.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, optsTest.wantErr)
+			}
+			if optsTest.wantErr {
+				return
+			}
+
+			if args := strings.Join(optsTest.opts.args(), " "); args != optsTest.wantArgs {
+				t.Errorf("args() = %s, want %s", args, optsTest.wantArgs)
+			}
+			if env := strings.Join(optsTest.opts.env(), " "); env != optsTest.wantEnv {
+				t.Errorf("env() = %s, want %s", env, optsTest.wantEnv)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package stemsplitter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/docker/docker/client"
+
+	"raga-recog-pipeline/pkg/progress"
+)
+
+// DEFAULT_LOCAL_COMMAND is the separator CLI LocalSplitter runs when none is
+// given. It is installed by `pip install demucs`.
+const DEFAULT_LOCAL_COMMAND = "demucs"
+
+// DEMUCS_STEMS are the stems of the four-source demucs models.
+var DEMUCS_STEMS = []string{"drums", "bass", "other", "vocals"}
+
+// StemSplitter separates a recording into stems. Split writes the stems of
+// audioIn to audioOut/<name>, where name is the file name of audioIn without
+// its extension, and returns them.
+type StemSplitter interface {
+	Split(ctx context.Context, audioIn, audioOut string) (StemSet, error)
+}
+
+// ProgressSplitter is a StemSplitter that can tell how far a single split
+// has got. SplitWithProgress calls report with the fraction of audioIn
+// separated as the split runs, see SeparationOptions.Progress.
+type ProgressSplitter interface {
+	StemSplitter
+	SplitWithProgress(ctx context.Context, audioIn, audioOut string, report func(fraction float64)) (StemSet, error)
+}
+
+// DockerSplitter runs demucs in a container built from docker/demucs.dockerfile.
+type DockerSplitter struct {
+	Client          *client.Client
+	ModelVolumePath string
+	Image           string
+	Options         SeparationOptions
+}
+
+func NewDockerSplitter(cli *client.Client, modelVolumePath, demucsImage string) *DockerSplitter {
+	return &DockerSplitter{Client: cli, ModelVolumePath: modelVolumePath, Image: demucsImage}
+}
+
+func (s *DockerSplitter) Split(ctx context.Context, audioIn, audioOut string) (StemSet, error) {
+	return RunStemSplitting(ctx, s.Client, audioIn, audioOut, s.ModelVolumePath, s.Image, s.Options)
+}
+
+func (s *DockerSplitter) SplitWithProgress(ctx context.Context, audioIn, audioOut string, report func(fraction float64)) (StemSet, error) {
+	opts := s.Options
+	opts.Progress = report
+	return RunStemSplitting(ctx, s.Client, audioIn, audioOut, s.ModelVolumePath, s.Image, opts)
+}
+
+// LocalSplitter runs a separator CLI installed on the host, such as demucs
+// from pip, as a subprocess.
+type LocalSplitter struct {
+	// Command is the executable to run. Empty uses DEFAULT_LOCAL_COMMAND.
+	Command string
+	// Args come before the demucs arguments, as in Command "python3" with
+	// Args "-m", "demucs".
+	Args    []string
+	Options SeparationOptions
+}
+
+func (s *LocalSplitter) Split(ctx context.Context, audioIn, audioOut string) (StemSet, error) {
+	return s.split(ctx, audioIn, audioOut, s.Options)
+}
+
+func (s *LocalSplitter) SplitWithProgress(ctx context.Context, audioIn, audioOut string, report func(fraction float64)) (StemSet, error) {
+	opts := s.Options
+	opts.Progress = report
+	return s.split(ctx, audioIn, audioOut, opts)
+}
+
+func (s *LocalSplitter) split(ctx context.Context, audioIn, audioOut string, opts SeparationOptions) (StemSet, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	absAudioIn, err := filepath.Abs(audioIn)
+	if err != nil {
+		return nil, fmt.Errorf("error getting absolute path for audio input: %v", err)
+	}
+	absAudioOut, err := filepath.Abs(audioOut)
+	if err != nil {
+		return nil, fmt.Errorf("error getting absolute path for audio output: %v", err)
+	}
+
+	command := s.Command
+	if command == "" {
+		command = DEFAULT_LOCAL_COMMAND
+	}
+
+	args := append(append([]string(nil), s.Args...), opts.args()...)
+	args = append(args, "--out", absAudioOut, absAudioIn)
+
+	jobCtx, cancel := jobContext(ctx, opts)
+	defer cancel()
+
+	tail := newTailWriter()
+	output := logWriter(tail, opts)
+	cmd := exec.CommandContext(jobCtx, command, args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.WaitDelay = LOCAL_WAIT_DELAY
+
+	if err := cmd.Run(); err != nil {
+		if jobCtx.Err() != nil {
+			return nil, jobError(ctx, jobCtx, opts, fmt.Errorf("failed to run %s: %w", command, jobCtx.Err()))
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			return nil, &ExitError{Name: command, ExitCode: int64(exitErr.ExitCode()), Logs: tail.Tail()}
+		}
+		return nil, fmt.Errorf("failed to run %s: %w: %s", command, err, tail.Tail())
+	}
+
+	return collectStems(absAudioIn, absAudioOut, opts.model())
+}
+
+// FakeSplitter writes placeholder stems without running a model, so that
+// callers can test their pipelines. Every stem holds the name of the stem
+// and the SHA-256 of the input, so the output only changes with the input.
+type FakeSplitter struct {
+	// Stems are the stems written. Empty uses DEMUCS_STEMS.
+	Stems []string
+	// Ext is the extension of the stems. Empty uses "wav".
+	Ext string
+	// Err, when set, is returned by every split instead of writing stems.
+	Err error
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (s *FakeSplitter) Split(ctx context.Context, audioIn, audioOut string) (StemSet, error) {
+	s.mu.Lock()
+	s.calls = append(s.calls, audioIn)
+	s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	content, err := os.ReadFile(audioIn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio input: %w", err)
+	}
+	sum := sha256.Sum256(content)
+
+	stems := s.Stems
+	if len(stems) == 0 {
+		stems = DEMUCS_STEMS
+	}
+	ext := s.Ext
+	if ext == "" {
+		ext = "wav"
+	}
+
+	destPath := filepath.Join(audioOut, trackName(audioIn))
+	if err := os.MkdirAll(destPath, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create target directory: %v", err)
+	}
+
+	set := StemSet{}
+	for _, stem := range stems {
+		stemPath := filepath.Join(destPath, stem+"."+ext)
+		if err := os.WriteFile(stemPath, []byte(stem+" "+hex.EncodeToString(sum[:])+"\n"), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write stem %s: %w", stemPath, err)
+		}
+		set[stem] = stemPath
+	}
+
+	return set, nil
+}
+
+// Calls returns the inputs Split was called with, in order.
+func (s *FakeSplitter) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// SplitFiles splits every file of audioIns with splitter, one after the
+// other since a single separation already keeps the GPU or CPU busy. report,
+// when set, is told as each file starts and finishes, and how far it has
+// got in between when splitter is a ProgressSplitter. A file that fails does
+// not stop the others. The stems of every file split are returned by input
+// path, along with the errors of every failed file.
+func SplitFiles(ctx context.Context, splitter StemSplitter, audioIns []string, audioOut string, report progress.Func) (map[string]StemSet, []error) {
+	tracker := progress.NewTracker(report, len(audioIns))
+
+	stems := make(map[string]StemSet, len(audioIns))
+	var errs []error
+	for _, audioIn := range audioIns {
+		tracker.Start(audioIn)
+
+		var set StemSet
+		var err error
+		if progressSplitter, ok := splitter.(ProgressSplitter); ok && report != nil {
+			set, err = progressSplitter.SplitWithProgress(ctx, audioIn, audioOut, func(fraction float64) {
+				tracker.Advance(audioIn, fraction)
+			})
+		} else {
+			set, err = splitter.Split(ctx, audioIn, audioOut)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to split %s: %w", audioIn, err))
+		} else {
+			stems[audioIn] = set
+		}
+
+		tracker.Done(audioIn)
+	}
+
+	return stems, errs
+}
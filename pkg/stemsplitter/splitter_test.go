@@ -0,0 +1,206 @@
+package stemsplitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"raga-recog-pipeline/pkg/progress"
+)
+
+// FAKE_DEMUCS stands in for the demucs CLI. It records its arguments, draws
+// a progress bar and writes one stem where demucs would:
+// <out>/<model>/<name>/vocals.mp3.
+const FAKE_DEMUCS = `#!/bin/sh
+printf '%s ' "$@" > "$(dirname "$0")/args"
+printf '\r  0%%|          | 0.0/60.0\r 50%%|#####     | 30.0/60.0\r100%%|##########| 60.0/60.0\n' >&2
+while [ $# -gt 1 ]; do
+	case "$1" in
+		-n) model="$2"; shift ;;
+		--out) out="$2"; shift ;;
+	esac
+	shift
+done
+name=$(basename "$1")
+name="${name%.*}"
+mkdir -p "$out/$model/$name"
+echo vocals > "$out/$model/$name/vocals.mp3"
+`
+
+func writeInput(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	return path
+}
+
+func TestFakeSplitter(t *testing.T) {
+	audioIn := writeInput(t, "todi.mp3", "todi")
+	audioOut := t.TempDir()
+
+	var splitter StemSplitter = &FakeSplitter{}
+	stems, err := splitter.Split(context.Background(), audioIn, audioOut)
+	if err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(audioOut, "todi"))
+	if err != nil {
+		t.Fatalf("Failed to read stems: %v", err)
+	}
+	if len(entries) != len(DEMUCS_STEMS) || len(stems) != len(DEMUCS_STEMS) {
+		t.Fatalf("Got %d stems in %v, want %d", len(entries), stems, len(DEMUCS_STEMS))
+	}
+	if stems["vocals"] != filepath.Join(audioOut, "todi", "vocals.wav") {
+		t.Errorf("Vocals at %s", stems["vocals"])
+	}
+
+	first, _ := os.ReadFile(stems["vocals"])
+	if _, err := splitter.Split(context.Background(), audioIn, audioOut); err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+	second, _ := os.ReadFile(stems["vocals"])
+	if len(first) == 0 || string(first) != string(second) {
+		t.Errorf("Fake stems differ between runs: %q and %q", first, second)
+	}
+}
+
+func TestSplitFiles(t *testing.T) {
+	audioIns := []string{writeInput(t, "todi.mp3", "todi"), filepath.Join(t.TempDir(), "missing.mp3"), writeInput(t, "kapi.mp3", "kapi")}
+	splitter := &FakeSplitter{Stems: []string{"vocals", "no_vocals"}}
+
+	var events []progress.Event
+	stems, errs := SplitFiles(context.Background(), splitter, audioIns, t.TempDir(), func(event progress.Event) {
+		events = append(events, event)
+	})
+
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "missing.mp3") {
+		t.Errorf("SplitFiles() errors = %v, want one for the missing file", errs)
+	}
+	if len(stems) != 2 || stems[audioIns[2]]["no_vocals"] == "" {
+		t.Errorf("SplitFiles() stems = %v, want those of both files split", stems)
+	}
+	if calls := splitter.Calls(); len(calls) != 3 {
+		t.Errorf("Split was called for %v, want every file", calls)
+	}
+	if last := events[len(events)-1]; len(events) != 6 || last.Completed != 3 || last.Total != 3 {
+		t.Errorf("Got %d progress events ending with %+v", len(events), last)
+	}
+
+	splitter.Err = errors.New("out of memory")
+	if _, errs := SplitFiles(context.Background(), splitter, audioIns[:1], t.TempDir(), nil); len(errs) != 1 || !errors.Is(errs[0], splitter.Err) {
+		t.Errorf("SplitFiles() errors = %v, want the splitter error", errs)
+	}
+}
+
+func TestSplitFiles_Progress(t *testing.T) {
+	command := filepath.Join(t.TempDir(), "demucs")
+	if err := os.WriteFile(command, []byte(FAKE_DEMUCS), 0755); err != nil {
+		t.Fatalf("Failed to write fake demucs: %v", err)
+	}
+	audioIn := writeInput(t, "kapi.mp3", "kapi")
+
+	var events []progress.Event
+	_, errs := SplitFiles(context.Background(), &LocalSplitter{Command: command}, []string{audioIn}, t.TempDir(), func(event progress.Event) {
+		events = append(events, event)
+	})
+	if len(errs) != 0 {
+		t.Fatalf("SplitFiles() errors = %v", errs)
+	}
+
+	// The progress bar of demucs moves the file along between its start
+	// and end.
+	var fractions []float64
+	for _, event := range events {
+		fractions = append(fractions, event.Fraction)
+	}
+	if want := []float64{0, 0, 0.5, 1, 0}; fmt.Sprint(fractions) != fmt.Sprint(want) {
+		t.Errorf("Reported fractions %v, want %v", fractions, want)
+	}
+	if last := events[len(events)-1]; last.Completed != 1 {
+		t.Errorf("Last event = %+v, want the file completed", last)
+	}
+}
+
+func TestLocalSplitter(t *testing.T) {
+	dir := t.TempDir()
+	command := filepath.Join(dir, "demucs")
+	if err := os.WriteFile(command, []byte(FAKE_DEMUCS), 0755); err != nil {
+		t.Fatalf("Failed to write fake demucs: %v", err)
+	}
+	audioIn := writeInput(t, "kapi.mp3", "kapi")
+	audioOut := t.TempDir()
+
+	var splitter StemSplitter = &LocalSplitter{Command: command, Options: SeparationOptions{Model: "mdx_extra", TwoStems: "vocals", Shifts: 2}}
+	stems, err := splitter.Split(context.Background(), audioIn, audioOut)
+	if err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+
+	if want := filepath.Join(audioOut, "kapi", "vocals.mp3"); len(stems) != 1 || stems["vocals"] != want {
+		t.Errorf("Split() stems = %v, want vocals at %s", stems, want)
+	}
+	if _, err := os.Stat(stems["vocals"]); err != nil {
+		t.Errorf("Stem was not moved out of the model directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(audioOut, "mdx_extra")); !os.IsNotExist(err) {
+		t.Errorf("Expected the model directory to be cleaned up, got %v", err)
+	}
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatalf("Failed to read fake demucs arguments: %v", err)
+	}
+	for _, want := range []string{"-n mdx_extra", "-d cpu", "--mp3", "--two-stems vocals", "--shifts 2"} {
+		if !strings.Contains(string(args), want) {
+			t.Errorf("demucs arguments %q do not contain %q", args, want)
+		}
+	}
+
+	var logs strings.Builder
+	failing := &LocalSplitter{
+		Command: "sh",
+		Args:    []string{"-c", "echo loading model; echo model not found >&2; exit 3", "demucs"},
+		Options: SeparationOptions{Logs: &logs},
+	}
+	_, err = failing.Split(context.Background(), audioIn, audioOut)
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 3 || !strings.Contains(exitErr.Logs, "model not found") {
+		t.Errorf("Split() error = %v, want an exit error with the output of the failed command", err)
+	}
+	if logs.String() != "loading model\nmodel not found\n" {
+		t.Errorf("Streamed logs = %q", logs.String())
+	}
+}
+
+func TestLocalSplitter_Timeout(t *testing.T) {
+	audioIn := writeInput(t, "kapi.mp3", "kapi")
+	sleeping := []string{"-c", "exec sleep 10", "demucs"}
+
+	splitter := &LocalSplitter{Command: "sh", Args: sleeping, Options: SeparationOptions{Timeout: 100 * time.Millisecond}}
+	start := time.Now()
+	_, err := splitter.Split(context.Background(), audioIn, t.TempDir())
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "did not finish within 100ms") {
+		t.Errorf("Split() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Split() took %v after timing out", elapsed)
+	}
+
+	// Cancelling the job is not reported as a timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	splitter.Options.Timeout = time.Minute
+	_, err = splitter.Split(ctx, audioIn, t.TempDir())
+	if !errors.Is(err, context.Canceled) || strings.Contains(err.Error(), "did not finish") {
+		t.Errorf("Split() error = %v, want a cancellation", err)
+	}
+}
@@ -0,0 +1,79 @@
+package stemsplitter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// StemSet maps the names of the stems of a recording, such as vocals, drums,
+// bass and other, or vocals and no_vocals, to the paths they were written
+// to.
+type StemSet map[string]string
+
+// Names returns the stem names of set in order.
+func (set StemSet) Names() []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// trackName is the name of the directory the stems of audioIn go to: its
+// file name without the extension.
+func trackName(audioIn string) string {
+	return strings.TrimSuffix(filepath.Base(audioIn), filepath.Ext(audioIn))
+}
+
+// collectStems moves the stems demucs wrote for audioIn with model to
+// audioOut/<name>, where name is the file name of audioIn without its
+// extension, and returns where they went. demucs writes them to
+// audioOut/<model>/<name>, so that is the only directory looked at, and
+// jobs with other models sharing audioOut are left alone.
+func collectStems(audioIn, audioOut, model string) (StemSet, error) {
+	track := trackName(audioIn)
+	destPath := filepath.Join(audioOut, track)
+	currSource := filepath.Join(audioOut, model, track)
+	if info, err := os.Stat(currSource); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("demucs wrote no stems for %s to %s", track, currSource)
+	}
+	defer func() {
+		os.RemoveAll(currSource)
+		// The model directory goes too once no other track is left in it.
+		os.Remove(filepath.Dir(currSource))
+	}()
+
+	entries, err := os.ReadDir(currSource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source directory: %v", err)
+	}
+
+	errs := moveFiles(currSource, destPath)
+
+	if errs != nil {
+		var errMsgs []string
+		for _, err := range errs {
+			if err != nil {
+				errMsgs = append(errMsgs, err.Error())
+			}
+		}
+		return nil, fmt.Errorf("errors occured while moving files: %s", strings.Join(errMsgs, "; "))
+	}
+
+	stems := StemSet{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		stems[trackName(entry.Name())] = filepath.Join(destPath, entry.Name())
+	}
+	if len(stems) == 0 {
+		return nil, fmt.Errorf("demucs wrote no stems for %s to %s", track, currSource)
+	}
+
+	return stems, nil
+}
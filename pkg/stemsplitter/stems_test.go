@@ -0,0 +1,86 @@
+package stemsplitter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeStems writes empty stems the way demucs does, to audioOut/dir/track.
+func writeStems(t *testing.T, audioOut, dir, track string, stems ...string) {
+	t.Helper()
+
+	trackDir := filepath.Join(audioOut, dir, track)
+	if err := os.MkdirAll(trackDir, os.ModePerm); err != nil {
+		t.Fatalf("Failed to create stem directory: %v", err)
+	}
+	for _, stem := range stems {
+		if err := os.WriteFile(filepath.Join(trackDir, stem), nil, 0644); err != nil {
+			t.Fatalf("Failed to write stem: %v", err)
+		}
+	}
+}
+
+type CollectStemsTest struct {
+	name  string
+	model string
+	dirs  []string
+	// others are directories of jobs with other models sharing audioOut.
+	others  []string
+	want    []string
+	wantErr string
+}
+
+func TestCollectStems(t *testing.T) {
+	tests := []CollectStemsTest{
+		{name: "model directory", model: "htdemucs_ft", dirs: []string{"htdemucs_ft"}, want: []string{"bass", "drums", "other", "vocals"}},
+		{name: "other model alongside", model: "mdx_extra", dirs: []string{"mdx_extra"}, others: []string{"htdemucs"}, want: []string{"bass", "drums", "other", "vocals"}},
+		{name: "requested model missing", model: "mdx_extra", others: []string{"htdemucs"}, wantErr: "demucs wrote no stems"},
+		{name: "default model", model: DEFAULT_MODEL, dirs: []string{DEFAULT_MODEL}, want: []string{"bass", "drums", "other", "vocals"}},
+		{name: "missing", model: DEFAULT_MODEL, wantErr: "demucs wrote no stems"},
+	}
+
+	for _, collectTest := range tests {
+		t.Run(collectTest.name, func(t *testing.T) {
+			audioOut := t.TempDir()
+			for _, dir := range append(collectTest.dirs, collectTest.others...) {
+				writeStems(t, audioOut, dir, "todi", "vocals.mp3", "drums.mp3", "bass.mp3", "other.mp3")
+			}
+
+			stems, err := collectStems("/music/todi.mp3", audioOut, collectTest.model)
+
+			// The stems of other models are never touched.
+			for _, dir := range collectTest.others {
+				if _, err := os.Stat(filepath.Join(audioOut, dir, "todi", "vocals.mp3")); err != nil {
+					t.Errorf("Stems of %s were disturbed: %v", dir, err)
+				}
+			}
+
+			if collectTest.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), collectTest.wantErr) {
+					t.Errorf("collectStems() error = %v, want %q", err, collectTest.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("collectStems() error = %v", err)
+			}
+
+			if got := strings.Join(stems.Names(), ","); got != strings.Join(collectTest.want, ",") {
+				t.Errorf("collectStems() stems = %s, want %v", got, collectTest.want)
+			}
+			for name, path := range stems {
+				if path != filepath.Join(audioOut, "todi", name+".mp3") {
+					t.Errorf("Stem %s at %s", name, path)
+				}
+				if _, err := os.Stat(path); err != nil {
+					t.Errorf("Stem %s missing: %v", name, err)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(audioOut, collectTest.dirs[0])); !os.IsNotExist(err) {
+				t.Errorf("Expected the model directory to be removed, got %v", err)
+			}
+		})
+	}
+}
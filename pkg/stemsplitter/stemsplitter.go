@@ -3,11 +3,11 @@ package stemsplitter
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 
 	"github.com/docker/docker/api/types"
@@ -16,6 +16,9 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"raga-recog-pipeline/pkg/progress"
 )
 
 const MAX_ALLOWABLE_CONCURRENCY = 10
@@ -127,14 +130,6 @@ func EnsureModelVolumeExists(ctx context.Context, cli *client.Client, volumeName
 	return CreateModelVolume(ctx, cli, volumeName)
 }
 
-func getEnv(key, defaultValue string) string {
-	value, exists := os.LookupEnv(key)
-	if !exists {
-		return defaultValue
-	}
-	return value
-}
-
 func moveFiles(sourceDir, targetDir string) []error {
 	if err := os.MkdirAll(targetDir, os.ModePerm); err != nil {
 		return []error{fmt.Errorf("failed to create target directory: %v", err)}
@@ -193,29 +188,33 @@ func moveFiles(sourceDir, targetDir string) []error {
 	return errs
 }
 
-func RunStemSplitting(ctx context.Context, cli *client.Client, audioIn, audioOut, modelVolumePath, demucsImage string) error {
+// RunStemSplitting separates audioIn into audioOut/<name> in a container of
+// demucsImage and returns the stems it wrote. The container is stopped and
+// removed however the job ends, including when ctx is cancelled or
+// opts.Timeout passes while demucs is still running.
+func RunStemSplitting(ctx context.Context, cli *client.Client, audioIn, audioOut, modelVolumePath, demucsImage string, opts SeparationOptions) (stems StemSet, err error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
 	absAudioIn, err := filepath.Abs(audioIn)
 	if err != nil {
-		return fmt.Errorf("error getting absolute path for audio input: %v", err)
+		return nil, fmt.Errorf("error getting absolute path for audio input: %v", err)
 	}
 	absAudioOut, err := filepath.Abs(audioOut)
 	if err != nil {
-		return fmt.Errorf("error getting absolute path for audio output: %v", err)
+		return nil, fmt.Errorf("error getting absolute path for audio output: %v", err)
 	}
 	absModelVolumePath, err := filepath.Abs(modelVolumePath)
 	if err != nil {
-		return fmt.Errorf("error getting absolute path for model volume: %v", err)
-	}
-	envVars := []string{
-		fmt.Sprintf("GPU=%s", getEnv("GPU", "false")),
-		fmt.Sprintf("MP3OUTPUT=%s", getEnv("MP3OUTPUT", "true")),
-		fmt.Sprintf("MODEL=%s", getEnv("MODEL", "htdemucs")),
+		return nil, fmt.Errorf("error getting absolute path for model volume: %v", err)
 	}
 
 	containerConfig := &container.Config{
-		Image: demucsImage,
-		Env:   envVars,
-		Cmd:   []string{filepath.Base(absAudioIn)},
+		Image:  demucsImage,
+		Env:    opts.env(),
+		Cmd:    []string{filepath.Base(absAudioIn)},
+		Labels: map[string]string{CONTAINER_LABEL: absAudioIn},
 	}
 
 	hostConfig := &container.HostConfig{
@@ -225,41 +224,70 @@ func RunStemSplitting(ctx context.Context, cli *client.Client, audioIn, audioOut
 			fmt.Sprintf("%s:/data/models", absModelVolumePath),
 		},
 	}
+	if opts.device() == DEVICE_CUDA {
+		hostConfig.DeviceRequests = []container.DeviceRequest{{Count: -1, Capabilities: [][]string{{"gpu"}}}}
+	}
+
+	jobCtx, cancel := jobContext(ctx, opts)
+	defer cancel()
 
-	resp, err := cli.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, "")
+	resp, err := cli.ContainerCreate(jobCtx, containerConfig, hostConfig, nil, nil, "")
 	if err != nil {
-		return err
+		return nil, jobError(ctx, jobCtx, opts, err)
 	}
-	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		return err
+	defer func() {
+		if removeErr := removeContainer(cli, resp.ID); removeErr != nil {
+			err = errors.Join(err, removeErr)
+		}
+	}()
+
+	if err := cli.ContainerStart(jobCtx, resp.ID, container.StartOptions{}); err != nil {
+		return nil, jobError(ctx, jobCtx, opts, err)
 	}
 
-	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	// The log stream follows the container until it exits. A caller's
+	// writer failing only cuts the copy short, never the separation.
+	tail := newTailWriter()
+	logs, err := cli.ContainerLogs(jobCtx, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		return nil, jobError(ctx, jobCtx, opts, fmt.Errorf("failed to read logs of container %s: %w", resp.ID, err))
+	}
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		output := logWriter(tail, opts)
+		stdcopy.StdCopy(output, output, logs)
+	}()
+	// Closing the stream ends the copy, which is waited for so that nothing
+	// writes to opts.Logs once the job has returned.
+	defer func() {
+		logs.Close()
+		<-copied
+	}()
+
+	statusCh, errCh := cli.ContainerWait(jobCtx, resp.ID, container.WaitConditionNotRunning)
 	select {
 	case err := <-errCh:
 		if err != nil {
-			return err
+			return nil, jobError(ctx, jobCtx, opts, err)
 		}
-	case <-statusCh:
-		// Container finished
-	}
-
-	dirWithoutExt := strings.TrimSuffix(filepath.Base(absAudioIn), filepath.Ext(absAudioIn))
-	destPath := fmt.Sprintf("%s/%s", absAudioOut, dirWithoutExt)
-	currSource := fmt.Sprintf("%s/htdemucs/%s", absAudioOut, dirWithoutExt)
-	defer os.RemoveAll(currSource)
-
-	errs := moveFiles(currSource, destPath)
-
-	if errs != nil {
-		var errMsgs []string
-		for _, err := range errs {
-			if err != nil {
-				errMsgs = append(errMsgs, err.Error())
-			}
+	case status := <-statusCh:
+		<-copied
+		if status.Error != nil {
+			return nil, fmt.Errorf("failed to wait for container %s: %s", resp.ID, status.Error.Message)
+		}
+		if status.StatusCode != 0 {
+			return nil, &ExitError{Name: "demucs container " + resp.ID, ExitCode: status.StatusCode, Logs: tail.Tail()}
 		}
-		return fmt.Errorf("errors occured while moving files: %s", strings.Join(errMsgs, "; "))
 	}
 
-	return nil
+	return collectStems(absAudioIn, absAudioOut, opts.model())
+}
+
+// RunStemSplittingFiles splits every file of audioIns in the demucs
+// container with opts, see SplitFiles.
+func RunStemSplittingFiles(ctx context.Context, cli *client.Client, audioIns []string, audioOut, modelVolumePath, demucsImage string, opts SeparationOptions, report progress.Func) (map[string]StemSet, []error) {
+	splitter := NewDockerSplitter(cli, modelVolumePath, demucsImage)
+	splitter.Options = opts
+	return SplitFiles(ctx, splitter, audioIns, audioOut, report)
 }
@@ -45,7 +45,19 @@ func checkDockerDaemon() error {
 	return nil
 }
 
+// requireDocker skips the Docker integration tests on machines without a
+// reachable Docker daemon.
+func requireDocker(t *testing.T) {
+	t.Helper()
+
+	if err := checkDockerDaemon(); err != nil {
+		t.Skip(err)
+	}
+}
+
 func TestPullSpleeterImage(t *testing.T) {
+	requireDocker(t)
+
 	ctx := context.Background()
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -91,6 +103,8 @@ func TestPullSpleeterImage(t *testing.T) {
 }
 
 func TestBuildImage(t *testing.T) {
+	requireDocker(t)
+
 	ctx := context.Background()
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -135,6 +149,8 @@ func TestBuildImage(t *testing.T) {
 }
 
 func TestEnsureModelVolumeExists(t *testing.T) {
+	requireDocker(t)
+
 	ctx := context.Background()
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -171,6 +187,8 @@ func TestEnsureModelVolumeExists(t *testing.T) {
 }
 
 func TestRunStemSplitting(t *testing.T) {
+	requireDocker(t)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
@@ -183,7 +201,7 @@ func TestRunStemSplitting(t *testing.T) {
 		t.Fatalf("Failed to build image: %v", err)
 	}
 
-	if err := RunStemSplitting(ctx, cli, TEST_AUDIO_IN, TEST_AUDIO_OUT, TEST_MODEL_VOLUME_DIR, DEMUCS_IMAGE_NAME); err != nil {
+	if _, err := RunStemSplitting(ctx, cli, TEST_AUDIO_IN, TEST_AUDIO_OUT, TEST_MODEL_VOLUME_DIR, DEMUCS_IMAGE_NAME, SeparationOptions{}); err != nil {
 		t.Fatalf("Failed to run stem splitting: %v", err)
 	}
 
@@ -201,14 +219,3 @@ func TestRunStemSplitting(t *testing.T) {
 		t.Fatalf("Did not produce split files as expected, only got %v", len(dirs))
 	}
 }
-
-func TestMain(m *testing.M) {
-	if err := checkDockerDaemon(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
-	exitVal := m.Run()
-
-	os.Exit(exitVal)
-}